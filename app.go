@@ -1,26 +1,64 @@
 package main
 
 import (
-	"os"
-	"log"
-	"strings"
-	"net/http"
 	"encoding/json"
-	"github.com/rs/cors"
-	"github.com/gorilla/mux"
 	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
 	"github.com/monostream/helmi/pkg/catalog"
+	"github.com/monostream/helmi/pkg/certificate"
+	"github.com/monostream/helmi/pkg/helm"
+	"github.com/monostream/helmi/pkg/kubectl"
 	"github.com/monostream/helmi/pkg/release"
+	"github.com/monostream/helmi/pkg/store"
+	"github.com/rs/cors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type App struct {
-	Catalog catalog.Catalog
+	Catalog *catalog.Watcher
+	Store   store.Store
 
 	Router *mux.Router
 }
 
-func (a *App) Initialize(path string) {
-	a.Catalog.Parse(path)
+func (a *App) Initialize(sources []catalog.Source) {
+	// catalog sources may read the kubernetes api
+	if err := kubectl.Configure(); err != nil {
+		log.Fatalf("Kubernetes: %v", err)
+	}
+
+	watcher, err := catalog.NewWatcher(sources...)
+
+	if err != nil {
+		log.Fatalf("Catalog: %v", err)
+	}
+
+	a.Catalog = watcher
+
+	if interval := getReloadInterval(); interval > 0 {
+		go a.Catalog.Watch(interval, nil)
+	}
+
+	if err := helm.Configure(); err != nil {
+		log.Fatalf("Helm: %v", err)
+	}
+
+	if err := certificate.Configure(); err != nil {
+		log.Fatalf("Certificates: %v", err)
+	}
+
+	stateStore, storeErr := store.NewStore()
+
+	if storeErr != nil {
+		log.Fatalf("Store: %v", storeErr)
+	}
+
+	a.Store = stateStore
 
 	a.Router = mux.NewRouter()
 	a.initializeRoutes()
@@ -34,8 +72,8 @@ func (a *App) Run(addr string) {
 	handler = handlers.CompressHandler(handler)
 
 	handler = cors.New(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{http.MethodHead, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions},
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{http.MethodHead, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
 		AllowCredentials: true,
 	}).Handler(handler)
 
@@ -44,14 +82,21 @@ func (a *App) Run(addr string) {
 }
 
 func (a *App) initializeRoutes() {
-	a.Router.HandleFunc("/v2/catalog", auth(a.getCatalog)).Methods(http.MethodGet)
-	a.Router.HandleFunc("/v2/service_instances/{serviceId}", auth(a.createInstance)).Methods(http.MethodPut)
-	a.Router.HandleFunc("/v2/service_instances/{serviceId}", auth(a.deleteInstance)).Methods(http.MethodDelete)
+	a.Router.HandleFunc("/v2/catalog", auth(apiVersion(a.getCatalog))).Methods(http.MethodGet)
+	a.Router.HandleFunc("/v2/service_instances/{serviceId}", auth(apiVersion(a.getInstance))).Methods(http.MethodGet)
+	a.Router.HandleFunc("/v2/service_instances/{serviceId}", auth(apiVersion(a.createInstance))).Methods(http.MethodPut)
+	a.Router.HandleFunc("/v2/service_instances/{serviceId}", auth(apiVersion(a.updateInstance))).Methods(http.MethodPatch)
+	a.Router.HandleFunc("/v2/service_instances/{serviceId}", auth(apiVersion(a.deleteInstance))).Methods(http.MethodDelete)
+
+	a.Router.HandleFunc("/v2/service_instances/{serviceId}/last_operation", auth(apiVersion(a.queryInstance))).Methods(http.MethodGet)
 
-	a.Router.HandleFunc("/v2/service_instances/{serviceId}/last_operation", auth(a.queryInstance)).Methods(http.MethodGet)
+	a.Router.HandleFunc("/v2/service_instances/{serviceId}/service_bindings/{bindingId}", auth(apiVersion(a.getBinding))).Methods(http.MethodGet)
+	a.Router.HandleFunc("/v2/service_instances/{serviceId}/service_bindings/{bindingId}", auth(apiVersion(a.bindInstance))).Methods(http.MethodPut)
+	a.Router.HandleFunc("/v2/service_instances/{serviceId}/service_bindings/{bindingId}", auth(apiVersion(a.unbindInstance))).Methods(http.MethodDelete)
 
-	a.Router.HandleFunc("/v2/service_instances/{serviceId}/service_bindings/{bindingId}", auth(a.bindInstance)).Methods(http.MethodPut)
-	a.Router.HandleFunc("/v2/service_instances/{serviceId}/service_bindings/{bindingId}", auth(a.unbindInstance)).Methods(http.MethodDelete)
+	a.Router.HandleFunc("/v2/service_instances/{serviceId}/service_bindings/{bindingId}/last_operation", auth(apiVersion(a.queryBinding))).Methods(http.MethodGet)
+
+	a.Router.HandleFunc("/admin/catalog", auth(a.getCatalogStatus)).Methods(http.MethodGet)
 
 	// endpoint to check if webservice is up
 	a.Router.HandleFunc("/liveness", a.livenessCheck).Methods(http.MethodGet)
@@ -63,6 +108,29 @@ func (a *App) livenessCheck(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, nil)
 }
 
+// getReloadInterval reads CATALOG_RELOAD_INTERVAL, 0 disables reloading
+func getReloadInterval() time.Duration {
+	interval, exists := os.LookupEnv("CATALOG_RELOAD_INTERVAL")
+
+	if !exists {
+		interval = "10s"
+	}
+
+	duration, err := time.ParseDuration(interval)
+
+	if err != nil {
+		log.Printf("Catalog: invalid reload interval %s", interval)
+		return 0
+	}
+
+	return duration
+}
+
+// getCatalogStatus shows the revision of the loaded catalog and the error of the last failed reload
+func (a *App) getCatalogStatus(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, a.Catalog.Status())
+}
+
 func checkCredentials(username string, password string) bool {
 	// if env variables are empty or not set ignore credentials
 	if user, isUserSet := os.LookupEnv("USERNAME"); isUserSet && len(user) > 0 {
@@ -86,14 +154,72 @@ func auth(handler http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// oldest supported minor version of the 2.x broker api
+const minimumApiMinorVersion = 12
+
+func apiVersion(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkApiVersion(r.Header.Get("X-Broker-API-Version")) {
+			respondWithJSONError(w, http.StatusPreconditionFailed, "", "Unsupported X-Broker-API-Version, supported are 2."+strconv.Itoa(minimumApiMinorVersion)+" and later")
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func checkApiVersion(version string) bool {
+	parts := strings.Split(strings.TrimSpace(version), ".")
+
+	if len(parts) != 2 {
+		return false
+	}
+
+	major, majorErr := strconv.Atoi(parts[0])
+	minor, minorErr := strconv.Atoi(parts[1])
+
+	return majorErr == nil && minorErr == nil && major == 2 && minor >= minimumApiMinorVersion
+}
+
+// getRequestContext completes the context with the organization and space of older cloud foundry requests
+func getRequestContext(context map[string]interface{}, organizationGuid string, spaceGuid string) map[string]interface{} {
+	if len(organizationGuid) == 0 && len(spaceGuid) == 0 {
+		return context
+	}
+
+	completed := map[string]interface{}{
+		"platform": "cloudfoundry",
+	}
+
+	for key, value := range context {
+		completed[key] = value
+	}
+
+	if _, exists := completed["organization_guid"]; !exists && len(organizationGuid) > 0 {
+		completed["organization_guid"] = organizationGuid
+	}
+
+	if _, exists := completed["space_guid"]; !exists && len(spaceGuid) > 0 {
+		completed["space_guid"] = spaceGuid
+	}
+
+	return completed
+}
+
 func (a *App) getCatalog(w http.ResponseWriter, r *http.Request) {
 	type PlanEntry struct {
 		Id          string `json:"id"`
 		Name        string `json:"name"`
 		Description string `json:"description"`
 
-		IsFree      bool `json:"free"`
-		IsBindable  bool `json:"bindable"`
+		IsFree     bool `json:"free"`
+		IsBindable bool `json:"bindable"`
+
+		Metadata *catalog.CatalogPlanMetadata `json:"metadata,omitempty"`
+
+		MaximumPollingDuration int `json:"maximum_polling_duration,omitempty"`
+
+		Schemas *catalog.CatalogSchemas `json:"schemas,omitempty"`
 	}
 
 	type ServiceEntry struct {
@@ -101,30 +227,48 @@ func (a *App) getCatalog(w http.ResponseWriter, r *http.Request) {
 		Name        string `json:"name"`
 		Description string `json:"description"`
 
+		Tags     []string `json:"tags,omitempty"`
+		Requires []string `json:"requires,omitempty"`
+
 		IsBindable  bool `json:"bindable"`
 		IsUpdatable bool `json:"plan_updateable"`
 
-		Plans []    PlanEntry `json:"plans"`
+		Metadata        *catalog.CatalogServiceMetadata `json:"metadata,omitempty"`
+		DashboardClient *catalog.CatalogDashboardClient `json:"dashboard_client,omitempty"`
+
+		IsInstancesRetrievable bool `json:"instances_retrievable"`
+		IsBindingsRetrievable  bool `json:"bindings_retrievable"`
+
+		Plans []PlanEntry `json:"plans"`
 	}
 
 	type Services struct {
-		Services [] ServiceEntry `json:"services"`
+		Services []ServiceEntry `json:"services"`
 	}
 
-	var serviceEntries [] ServiceEntry
+	var serviceEntries []ServiceEntry
 
-	for _, service := range a.Catalog.Services {
+	for _, service := range a.Catalog.Current().Services {
 		serviceEntry := ServiceEntry{
 			Id:   service.Id,
 			Name: service.Name,
 
 			Description: service.Description,
 
-			IsBindable:  true,
-			IsUpdatable: false,
+			Tags:     service.Tags,
+			Requires: service.Requires,
+
+			IsBindable:  service.IsBindable(),
+			IsUpdatable: service.PlanUpdatable,
+
+			Metadata:        service.Metadata,
+			DashboardClient: service.DashboardClient,
+
+			IsInstancesRetrievable: true,
+			IsBindingsRetrievable:  true,
 		}
 
-		var planEntries [] PlanEntry
+		var planEntries []PlanEntry
 
 		for _, plan := range service.Plans {
 			planEntry := PlanEntry{
@@ -133,8 +277,14 @@ func (a *App) getCatalog(w http.ResponseWriter, r *http.Request) {
 
 				Description: plan.Description,
 
-				IsFree:     true,
-				IsBindable: true,
+				IsFree:     plan.IsFree(),
+				IsBindable: plan.IsBindable(service),
+
+				Metadata: plan.Metadata,
+
+				MaximumPollingDuration: plan.MaximumPollingDuration,
+
+				Schemas: plan.Schemas,
 			}
 
 			planEntries = append(planEntries, planEntry)
@@ -151,7 +301,65 @@ func (a *App) getCatalog(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, services)
 }
 
+func (a *App) getInstance(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serviceId := vars["serviceId"]
+
+	type responseData struct {
+		ServiceId    string                 `json:"service_id"`
+		PlanId       string                 `json:"plan_id"`
+		DashboardUrl string                 `json:"dashboard_url,omitempty"`
+		Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	}
+
+	instance, err := a.Store.GetInstance(serviceId)
+
+	if err != nil {
+		respondWithServerError(w, err)
+		return
+	}
+
+	if instance == nil {
+		respondWithJSON(w, http.StatusNotFound, nil)
+		return
+	}
+
+	if operation := instance.LastOperation(); operation != nil && operation.State == store.StateInProgress {
+		if operation.Type == store.OperationProvision {
+			respondWithJSON(w, http.StatusNotFound, nil)
+			return
+		}
+
+		if operation.Type == store.OperationUpdate {
+			respondWithJSONError(w, http.StatusUnprocessableEntity, "ConcurrencyError", "Service instance is being updated")
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, responseData{
+		ServiceId:    instance.ServiceId,
+		PlanId:       instance.PlanId,
+		DashboardUrl: a.getDashboardUrl(a.Catalog.Current(), instance.ServiceId, instance.PlanId, serviceId),
+		Parameters:   instance.Parameters,
+	})
+}
+
+// getDashboardUrl resolves the dashboard url of an instance, a failed lookup only omits it
+func (a *App) getDashboardUrl(current *catalog.Catalog, serviceId string, planId string, id string) string {
+	dashboardUrl, err := release.GetDashboardUrl(current, a.Store, serviceId, planId, id)
+
+	// releases installed before the store existed have no dashboard
+	if err != nil && err != release.ErrInstanceNotFound {
+		log.Printf("Dashboard: failed to resolve url of instance %s: %v", id, err)
+	}
+
+	return dashboardUrl
+}
+
 func (a *App) createInstance(w http.ResponseWriter, r *http.Request) {
+	// the catalog may be reloaded during the request
+	current := a.Catalog.Current()
+
 	vars := mux.Vars(r)
 	serviceId := vars["serviceId"]
 	acceptsIncomplete := strings.EqualFold(r.URL.Query().Get("accepts_incomplete"), "true")
@@ -159,6 +367,13 @@ func (a *App) createInstance(w http.ResponseWriter, r *http.Request) {
 	type requestData struct {
 		ServiceId string `json:"service_id"`
 		PlanId    string `json:"plan_id"`
+
+		Parameters map[string]interface{} `json:"parameters"`
+		Context    map[string]interface{} `json:"context"`
+
+		// deprecated in favor of the context, but still sent by cloud foundry
+		OrganizationGuid string `json:"organization_guid"`
+		SpaceGuid        string `json:"space_guid"`
 	}
 
 	var data requestData
@@ -170,22 +385,230 @@ func (a *App) createInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := release.Install(&a.Catalog, data.ServiceId, data.PlanId, serviceId, acceptsIncomplete)
+	if err := release.ValidateId(serviceId); err != nil {
+		respondWithUserError(w, err.Error())
+		return
+	}
+
+	if _, err := current.GetServicePlan(data.ServiceId, data.PlanId); err != nil {
+		respondWithUserError(w, "Unknown Service or Plan")
+		return
+	}
+
+	if err := release.ValidateParameters(current, data.ServiceId, data.PlanId, data.Parameters); err != nil {
+		respondWithUserError(w, err.Error())
+		return
+	}
+
+	if !acceptsIncomplete && release.RequiresAsync(current, data.ServiceId, data.PlanId) {
+		respondWithAsyncRequired(w)
+		return
+	}
+
+	context := getRequestContext(data.Context, data.OrganizationGuid, data.SpaceGuid)
+
+	if !release.IsAvailable(current, data.ServiceId, data.PlanId, context) {
+		respondWithUserError(w, "Plan is not available for this platform or organization")
+		return
+	}
+
+	comparison, err := release.CompareInstance(current, a.Store, data.ServiceId, data.PlanId, serviceId, data.Parameters)
+
+	// a failed instance is reported by last_operation and has to be deprovisioned before it is provisioned again
+	if err == release.ErrProvisionFailed {
+		respondWithJSONError(w, http.StatusConflict, "", "Service instance provisioning failed, deprovision it before provisioning it again")
+		return
+	}
 
 	if err != nil {
-		exists, existsErr := release.Exists(serviceId)
+		respondWithServerError(w, err)
+		return
+	}
 
-		if existsErr == nil && exists {
-			respondWithJSON(w, http.StatusConflict, nil)
+	switch comparison {
+	case release.Identical:
+		respondWithJSON(w, http.StatusOK, getDashboardResponse(a.getDashboardUrl(current, data.ServiceId, data.PlanId, serviceId)))
+		return
+	case release.InProgress:
+		if !acceptsIncomplete {
+			respondWithConcurrencyError(w)
 			return
 		}
 
+		operation, err := release.GetOperation(a.Store, serviceId, "")
+
+		if err != nil {
+			respondWithServerError(w, err)
+			return
+		}
+
+		respondWithOperationAccepted(w, operation)
+		return
+	case release.Conflicting:
+		respondWithJSON(w, http.StatusConflict, nil)
+		return
+	}
+
+	operation, err := release.Install(current, a.Store, data.ServiceId, data.PlanId, serviceId, data.Parameters, context, acceptsIncomplete)
+
+	if err == release.ErrInstanceExists {
+		respondWithJSON(w, http.StatusConflict, nil)
+		return
+	}
+
+	if err == release.ErrOperationInProgress {
+		respondWithConcurrencyError(w)
+		return
+	}
+
+	if err != nil {
 		respondWithServerError(w, err)
 		return
 	}
 
+	response := getDashboardResponse(a.getDashboardUrl(current, data.ServiceId, data.PlanId, serviceId))
+
 	if acceptsIncomplete {
-		respondWithJSON(w, http.StatusAccepted, nil)
+		if operation != nil {
+			response["operation"] = operation.Id
+		}
+
+		respondWithJSON(w, http.StatusAccepted, response)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func getDashboardResponse(dashboardUrl string) map[string]string {
+	response := map[string]string{}
+
+	if len(dashboardUrl) > 0 {
+		response["dashboard_url"] = dashboardUrl
+	}
+
+	return response
+}
+
+func (a *App) updateInstance(w http.ResponseWriter, r *http.Request) {
+	// the catalog may be reloaded during the request
+	current := a.Catalog.Current()
+
+	vars := mux.Vars(r)
+	serviceId := vars["serviceId"]
+	acceptsIncomplete := strings.EqualFold(r.URL.Query().Get("accepts_incomplete"), "true")
+
+	type previousValues struct {
+		PlanId string `json:"plan_id"`
+	}
+
+	type requestData struct {
+		ServiceId string `json:"service_id"`
+		PlanId    string `json:"plan_id"`
+
+		Parameters     map[string]interface{} `json:"parameters"`
+		PreviousValues previousValues         `json:"previous_values"`
+	}
+
+	var data requestData
+	decoder := json.NewDecoder(r.Body)
+	decoderErr := decoder.Decode(&data)
+
+	if decoderErr != nil || len(data.ServiceId) == 0 {
+		respondWithUserError(w, "Invalid Request")
+		return
+	}
+
+	instance, err := a.Store.GetInstance(serviceId)
+
+	if err != nil {
+		respondWithServerError(w, err)
+		return
+	}
+
+	// an instance can not be moved to another service
+	if instance != nil && !strings.EqualFold(data.ServiceId, instance.ServiceId) {
+		respondWithUserError(w, "service_id does not match the service of the instance")
+		return
+	}
+
+	service, err := current.GetService(data.ServiceId)
+
+	if err != nil {
+		respondWithUserError(w, "Unknown Service")
+		return
+	}
+
+	// previous values are optional and may be stale, they are only used for releases missing in the store
+	currentPlanId := data.PreviousValues.PlanId
+
+	if instance != nil {
+		currentPlanId = instance.PlanId
+	}
+
+	// parameter changes keep the current plan
+	if len(data.PlanId) == 0 {
+		data.PlanId = currentPlanId
+	}
+
+	if len(data.PlanId) == 0 {
+		if len(data.Parameters) > 0 {
+			respondWithUserError(w, "plan_id is required to update parameters")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, nil)
+		return
+	}
+
+	plan, _ := current.GetServicePlan(data.ServiceId, data.PlanId)
+
+	if len(plan.Id) == 0 {
+		respondWithUserError(w, "Unknown Plan")
+		return
+	}
+
+	if !service.PlanUpdatable && len(currentPlanId) > 0 && !strings.EqualFold(data.PlanId, currentPlanId) {
+		respondWithJSONError(w, http.StatusUnprocessableEntity, "", "Service does not support plan changes")
+		return
+	}
+
+	if err := release.ValidateUpdateParameters(current, data.ServiceId, data.PlanId, data.Parameters); err != nil {
+		respondWithUserError(w, err.Error())
+		return
+	}
+
+	if !acceptsIncomplete && release.RequiresAsync(current, data.ServiceId, data.PlanId) {
+		respondWithAsyncRequired(w)
+		return
+	}
+
+	operation, err := release.Update(current, a.Store, data.ServiceId, data.PlanId, serviceId, data.Parameters, acceptsIncomplete)
+
+	if err == release.ErrOperationInProgress {
+		respondWithConcurrencyError(w)
+		return
+	}
+
+	if err == release.ErrPlanNotAvailable {
+		respondWithUserError(w, "Plan is not available for this platform or organization")
+		return
+	}
+
+	if err != nil {
+		exists, existsErr := release.Exists(a.Store, serviceId)
+
+		if existsErr == nil && !exists {
+			respondWithJSONError(w, http.StatusUnprocessableEntity, "", "Service instance does not exist")
+			return
+		}
+
+		respondWithServerError(w, err)
+		return
+	}
+
+	if acceptsIncomplete {
+		respondWithOperationAccepted(w, operation)
 		return
 	}
 
@@ -197,15 +620,26 @@ func (a *App) deleteInstance(w http.ResponseWriter, r *http.Request) {
 	serviceId := vars["serviceId"]
 	acceptsIncomplete := strings.EqualFold(r.URL.Query().Get("accepts_incomplete"), "true")
 
-	err := release.Delete(serviceId)
+	if !acceptsIncomplete && release.RequiresAsync(a.Catalog.Current(), r.URL.Query().Get("service_id"), r.URL.Query().Get("plan_id")) {
+		respondWithAsyncRequired(w)
+		return
+	}
+
+	operation, err := release.Delete(a.Store, serviceId, acceptsIncomplete)
+
+	if err == release.ErrOperationInProgress {
+		respondWithConcurrencyError(w)
+		return
+	}
 
 	if err != nil {
 		respondWithServerError(w, err)
 		return
 	}
 
-	if acceptsIncomplete {
-		respondWithJSON(w, http.StatusAccepted, nil)
+	// releases without recorded instance are deleted synchronously
+	if acceptsIncomplete && operation != nil {
+		respondWithOperationAccepted(w, operation)
 		return
 	}
 
@@ -216,47 +650,78 @@ func (a *App) queryInstance(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	serviceId := vars["serviceId"]
 
-	status, err := release.GetStatus(serviceId)
+	operation, err := release.GetOperation(a.Store, serviceId, r.URL.Query().Get("operation"))
 
-	if err != nil {
-		exists, existsErr := release.Exists(serviceId)
+	// instances disappear once they are deprovisioned
+	if err == release.ErrInstanceNotFound {
+		respondWithJSON(w, http.StatusGone, nil)
+		return
+	}
 
-		if existsErr == nil && !exists {
-			respondWithJSON(w, http.StatusGone, nil)
-			return
-		}
+	if err == release.ErrOperationNotFound {
+		respondWithUserError(w, "Unknown Operation")
+		return
+	}
 
+	if err != nil {
 		respondWithServerError(w, err)
 		return
 	}
 
-	respondWithState := func(state string) {
-		respondWithJSON(w, http.StatusOK, map[string]string{
-			"state": state,
-		})
+	response := getOperationResponse(operation)
+
+	// the dashboard may only be reachable once the operation succeeded
+	if operation.State == store.StateSucceeded && operation.Type != store.OperationDeprovision {
+		if dashboardUrl := a.getDashboardUrl(a.Catalog.Current(), "", "", serviceId); len(dashboardUrl) > 0 {
+			response["dashboard_url"] = dashboardUrl
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (a *App) getBinding(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serviceId := vars["serviceId"]
+	bindingId := vars["bindingId"]
+
+	type responseData struct {
+		UserCredentials map[string]interface{} `json:"credentials"`
+		Parameters      map[string]interface{} `json:"parameters,omitempty"`
 	}
 
-	if status.IsFailed {
-		respondWithState("failed")
+	binding, credentials, err := release.GetBinding(a.Catalog.Current(), a.Store, serviceId, bindingId)
+
+	if err == release.ErrBindingNotFound {
+		respondWithJSON(w, http.StatusNotFound, nil)
 		return
 	}
 
-	if status.IsAvailable {
-		respondWithState("succeeded")
+	if err != nil {
+		respondWithServerError(w, err)
 		return
 	}
 
-	respondWithState("in progress")
+	respondWithJSON(w, http.StatusOK, responseData{
+		UserCredentials: credentials,
+		Parameters:      binding.Parameters,
+	})
 }
 
 func (a *App) bindInstance(w http.ResponseWriter, r *http.Request) {
+	// the catalog may be reloaded during the request
+	current := a.Catalog.Current()
+
 	vars := mux.Vars(r)
 	serviceId := vars["serviceId"]
 	bindingId := vars["bindingId"]
+	acceptsIncomplete := strings.EqualFold(r.URL.Query().Get("accepts_incomplete"), "true")
 
 	type requestData struct {
 		ServiceId string `json:"service_id"`
 		PlanId    string `json:"plan_id"`
+
+		Parameters map[string]interface{} `json:"parameters"`
 	}
 
 	type credentialsWrapper struct {
@@ -267,18 +732,100 @@ func (a *App) bindInstance(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	decoderErr := decoder.Decode(&data)
 
-	if decoderErr != nil || len(data.ServiceId) == 0 || len(data.PlanId) == 0 {
+	if decoderErr != nil {
 		respondWithUserError(w, "Invalid Request")
 		return
 	}
 
-	credentials, err := release.GetCredentials(&a.Catalog, data.ServiceId, data.PlanId, serviceId)
+	// service and plan are known for instances in the store
+	if len(data.ServiceId) == 0 || len(data.PlanId) == 0 {
+		instance, err := a.Store.GetInstance(serviceId)
+
+		if err != nil {
+			respondWithServerError(w, err)
+			return
+		}
+
+		if instance == nil {
+			respondWithUserError(w, "Invalid Request")
+			return
+		}
+
+		data.ServiceId = instance.ServiceId
+		data.PlanId = instance.PlanId
+	}
+
+	service, _ := current.GetService(data.ServiceId)
+	plan, err := current.GetServicePlan(data.ServiceId, data.PlanId)
+
+	if err != nil {
+		respondWithUserError(w, "Unknown Service or Plan")
+		return
+	}
+
+	if !plan.IsBindable(service) {
+		respondWithUserError(w, "Plan is not bindable")
+		return
+	}
+
+	if err := release.ValidateBindingParameters(current, data.ServiceId, data.PlanId, data.Parameters); err != nil {
+		respondWithUserError(w, err.Error())
+		return
+	}
+
+	comparison, err := release.CompareBinding(a.Store, data.ServiceId, data.PlanId, serviceId, bindingId, data.Parameters)
+
+	if err != nil {
+		respondWithServerError(w, err)
+		return
+	}
+
+	if comparison == release.Conflicting {
+		respondWithJSON(w, http.StatusConflict, nil)
+		return
+	}
+
+	// an identical binding returns the already created credentials
+	status := http.StatusCreated
+
+	if comparison == release.Identical {
+		status = http.StatusOK
+	}
+
+	if acceptsIncomplete {
+		binding, err := release.BindAsync(current, a.Store, data.ServiceId, data.PlanId, serviceId, bindingId, data.Parameters)
+
+		if err == release.ErrOperationInProgress {
+			respondWithConcurrencyError(w)
+			return
+		}
+
+		if err != nil {
+			respondWithServerError(w, err)
+			return
+		}
+
+		if operation := binding.LastOperation(); operation != nil && operation.State == store.StateInProgress {
+			respondWithOperationAccepted(w, operation)
+			return
+		}
+
+		respondWithJSON(w, status, credentialsWrapper{UserCredentials: binding.Credentials})
+		return
+	}
+
+	credentials, err := release.Bind(current, a.Store, data.ServiceId, data.PlanId, serviceId, bindingId, data.Parameters)
+
+	if err == release.ErrOperationInProgress {
+		respondWithConcurrencyError(w)
+		return
+	}
 
 	if err != nil {
-		exists, existsErr := release.Exists(serviceId)
+		exists, existsErr := release.Exists(a.Store, serviceId)
 
 		if existsErr == nil && !exists {
-			respondWithJSON(w, http.StatusConflict, nil)
+			respondWithUserError(w, "Service instance does not exist")
 			return
 		}
 
@@ -286,9 +833,33 @@ func (a *App) bindInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_ = bindingId
+	respondWithJSON(w, status, credentialsWrapper{UserCredentials: credentials})
+}
 
-	respondWithJSON(w, http.StatusOK, credentialsWrapper{ UserCredentials: credentials })
+func (a *App) queryBinding(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	serviceId := vars["serviceId"]
+	bindingId := vars["bindingId"]
+
+	operation, err := release.GetBindingOperation(a.Store, serviceId, bindingId, r.URL.Query().Get("operation"))
+
+	// bindings disappear once they are unbound
+	if err == release.ErrBindingNotFound {
+		respondWithJSON(w, http.StatusGone, nil)
+		return
+	}
+
+	if err == release.ErrOperationNotFound {
+		respondWithUserError(w, "Unknown Operation")
+		return
+	}
+
+	if err != nil {
+		respondWithServerError(w, err)
+		return
+	}
+
+	respondWithOperation(w, operation)
 }
 
 func (a *App) unbindInstance(w http.ResponseWriter, r *http.Request) {
@@ -296,7 +867,10 @@ func (a *App) unbindInstance(w http.ResponseWriter, r *http.Request) {
 	serviceId := vars["serviceId"]
 	bindingId := vars["bindingId"]
 
-	exists, err := release.Exists(serviceId)
+	query := r.URL.Query()
+	acceptsIncomplete := strings.EqualFold(query.Get("accepts_incomplete"), "true")
+
+	exists, err := release.Exists(a.Store, serviceId)
 
 	if err != nil {
 		respondWithServerError(w, err)
@@ -308,7 +882,44 @@ func (a *App) unbindInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_ = bindingId
+	if acceptsIncomplete {
+		operation, err := release.UnbindAsync(a.Catalog.Current(), a.Store, query.Get("service_id"), query.Get("plan_id"), serviceId, bindingId)
+
+		if err == release.ErrBindingNotFound {
+			respondWithJSON(w, http.StatusGone, nil)
+			return
+		}
+
+		if err == release.ErrOperationInProgress {
+			respondWithConcurrencyError(w)
+			return
+		}
+
+		if err != nil {
+			respondWithServerError(w, err)
+			return
+		}
+
+		respondWithOperationAccepted(w, operation)
+		return
+	}
+
+	err = release.Unbind(a.Catalog.Current(), a.Store, query.Get("service_id"), query.Get("plan_id"), serviceId, bindingId)
+
+	if err == release.ErrBindingNotFound {
+		respondWithJSON(w, http.StatusGone, nil)
+		return
+	}
+
+	if err == release.ErrOperationInProgress {
+		respondWithConcurrencyError(w)
+		return
+	}
+
+	if err != nil {
+		respondWithServerError(w, err)
+		return
+	}
 
 	respondWithJSON(w, http.StatusOK, nil)
 }
@@ -317,8 +928,52 @@ func respondWithUserError(w http.ResponseWriter, description string) {
 	respondWithJSONError(w, http.StatusBadRequest, "", description)
 }
 
+// respondWithServerError hides the raw helm and kubectl output, which is logged instead
 func respondWithServerError(w http.ResponseWriter, error error) {
-	respondWithJSONError(w, http.StatusInternalServerError, "", error.Error())
+	description := strings.TrimSpace(error.Error())
+
+	if index := strings.Index(description, "\n"); index >= 0 {
+		description = description[:index]
+	}
+
+	description = strings.TrimPrefix(description, "Error: ")
+
+	respondWithJSONError(w, http.StatusInternalServerError, "", description)
+}
+
+func respondWithOperation(w http.ResponseWriter, operation *store.Operation) {
+	respondWithJSON(w, http.StatusOK, getOperationResponse(operation))
+}
+
+func getOperationResponse(operation *store.Operation) map[string]string {
+	response := map[string]string{
+		"state": operation.State,
+	}
+
+	if len(operation.Description) > 0 {
+		response["description"] = operation.Description
+	}
+
+	return response
+}
+
+func respondWithOperationAccepted(w http.ResponseWriter, operation *store.Operation) {
+	if operation == nil {
+		respondWithJSON(w, http.StatusAccepted, nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, map[string]string{
+		"operation": operation.Id,
+	})
+}
+
+func respondWithAsyncRequired(w http.ResponseWriter) {
+	respondWithJSONError(w, http.StatusUnprocessableEntity, "AsyncRequired", "This service plan requires client support for asynchronous service operations.")
+}
+
+func respondWithConcurrencyError(w http.ResponseWriter) {
+	respondWithJSONError(w, http.StatusUnprocessableEntity, "ConcurrencyError", "Another operation for this service instance is in progress.")
 }
 
 func respondWithJSONError(w http.ResponseWriter, code int, error string, description string) {
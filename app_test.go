@@ -0,0 +1,204 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/monostream/helmi/pkg/catalog"
+	"github.com/monostream/helmi/pkg/store"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const mariadbService = "ab53df4d-c279-4880-94f7-65e7d72b7834"
+const mariadbPlan = "e79306ef-4e10-4e3d-b38e-ffce88c90f59"
+const minioService = "8dda5a6f-f796-4b52-806f-4129d7576d6e"
+const minioPlan = "f003f191-c250-4e85-9abd-038af629ad71"
+
+const instanceId = "09a22eb6-c23c-4a33-b074-b7ef082a5759"
+const bindingId = "5c5e5b0e-1f4d-4a44-9d4f-0b2c4c1ee7b1"
+
+func red(msg string) string {
+	return "\033[31m" + msg + "\033[39m\n\n"
+}
+
+// newTestApp serves catalog.yaml with a file store in a temporary directory
+func newTestApp(t *testing.T) (*App, func()) {
+	directory, _ := ioutil.TempDir("", "helmi")
+
+	stateStore, _ := store.NewFileStore(filepath.Join(directory, "helmi.db"))
+	watcher, err := catalog.NewWatcher(catalog.NewFileSource("catalog.yaml"))
+
+	if err != nil {
+		t.Fatal(red("failed to load catalog: " + err.Error()))
+	}
+
+	a := &App{Catalog: watcher, Store: stateStore, Router: mux.NewRouter()}
+	a.initializeRoutes()
+
+	return a, func() {
+		os.RemoveAll(directory)
+	}
+}
+
+func serve(a *App, method string, url string, body string) *httptest.ResponseRecorder {
+	return serveVersion(a, "2.14", method, url, body)
+}
+
+func serveVersion(a *App, version string, method string, url string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+
+	if len(version) > 0 {
+		r.Header.Set("X-Broker-API-Version", version)
+	}
+
+	w := httptest.NewRecorder()
+	a.Router.ServeHTTP(w, r)
+
+	return w
+}
+
+func Test_UpdateInstanceService(t *testing.T) {
+	a, cleanup := newTestApp(t)
+	defer cleanup()
+
+	a.Store.SaveInstance(&store.Instance{Id: instanceId, ServiceId: mariadbService, PlanId: mariadbPlan})
+
+	url := "/v2/service_instances/" + instanceId
+
+	if w := serve(a, http.MethodPatch, url, `{"service_id": "`+minioService+`", "plan_id": "`+minioPlan+`"}`); w.Code != http.StatusBadRequest {
+		t.Error(red("update to a foreign service not rejected: " + w.Body.String()))
+	}
+	if w := serve(a, http.MethodPatch, url, `{"service_id": "`+mariadbService+`", "plan_id": "`+minioPlan+`"}`); w.Code != http.StatusBadRequest {
+		t.Error(red("update to a foreign plan not rejected: " + w.Body.String()))
+	}
+
+	a.Store.DeleteInstance(instanceId)
+
+	if w := serve(a, http.MethodPatch, url, `{"service_id": "unknown"}`); w.Code != http.StatusBadRequest {
+		t.Error(red("update of an unknown service not rejected: " + w.Body.String()))
+	}
+}
+
+func Test_ApiVersion(t *testing.T) {
+	a, cleanup := newTestApp(t)
+	defer cleanup()
+
+	if w := serveVersion(a, "", http.MethodGet, "/v2/catalog", ""); w.Code != http.StatusPreconditionFailed {
+		t.Error(red("request without api version not rejected"))
+	}
+	if w := serveVersion(a, "2.11", http.MethodGet, "/v2/catalog", ""); w.Code != http.StatusPreconditionFailed {
+		t.Error(red("request with old api version not rejected"))
+	}
+	if w := serveVersion(a, "1.14", http.MethodGet, "/v2/catalog", ""); w.Code != http.StatusPreconditionFailed {
+		t.Error(red("request with old major api version not rejected"))
+	}
+	if w := serveVersion(a, "2.14", http.MethodGet, "/v2/catalog", ""); w.Code != http.StatusOK {
+		t.Error(red("request with current api version rejected: " + w.Body.String()))
+	}
+}
+
+func Test_GetInstance(t *testing.T) {
+	a, cleanup := newTestApp(t)
+	defer cleanup()
+
+	url := "/v2/service_instances/" + instanceId
+
+	if w := serve(a, http.MethodGet, url, ""); w.Code != http.StatusNotFound {
+		t.Error(red("unknown instance not reported as missing"))
+	}
+
+	instance := &store.Instance{Id: instanceId, ServiceId: mariadbService, PlanId: mariadbPlan}
+	instance.StartOperation(store.OperationProvision)
+	a.Store.SaveInstance(instance)
+
+	if w := serve(a, http.MethodGet, url, ""); w.Code != http.StatusNotFound {
+		t.Error(red("instance being provisioned not reported as missing"))
+	}
+
+	instance.StartOperation(store.OperationUpdate)
+	a.Store.SaveInstance(instance)
+
+	w := serve(a, http.MethodGet, url, "")
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Error(red("instance being updated not reported as concurrency error"))
+	}
+	if !strings.Contains(w.Body.String(), "ConcurrencyError") {
+		t.Error(red("concurrency error missing in body: " + w.Body.String()))
+	}
+}
+
+func Test_GetBinding(t *testing.T) {
+	a, cleanup := newTestApp(t)
+	defer cleanup()
+
+	a.Store.SaveInstance(&store.Instance{Id: instanceId, ServiceId: mariadbService, PlanId: mariadbPlan})
+
+	url := "/v2/service_instances/" + instanceId + "/service_bindings/" + bindingId
+
+	if w := serve(a, http.MethodGet, url, ""); w.Code != http.StatusNotFound {
+		t.Error(red("unknown binding not reported as missing"))
+	}
+
+	binding := &store.Binding{Id: bindingId, InstanceId: instanceId, ServiceId: mariadbService, PlanId: mariadbPlan}
+	operation := binding.StartOperation(store.OperationBind)
+	a.Store.SaveBinding(binding)
+
+	if w := serve(a, http.MethodGet, url, ""); w.Code != http.StatusNotFound {
+		t.Error(red("binding being created not reported as missing"))
+	}
+
+	operation.State = store.StateSucceeded
+	binding.Credentials = map[string]interface{}{"user": "helmi"}
+	a.Store.SaveBinding(binding)
+
+	w := serve(a, http.MethodGet, url, "")
+
+	if w.Code != http.StatusOK {
+		t.Error(red("binding not returned: " + w.Body.String()))
+	}
+	if !strings.Contains(w.Body.String(), `"user":"helmi"`) {
+		t.Error(red("credentials missing in body: " + w.Body.String()))
+	}
+}
+
+func Test_QueryBinding(t *testing.T) {
+	a, cleanup := newTestApp(t)
+	defer cleanup()
+
+	a.Store.SaveInstance(&store.Instance{Id: instanceId, ServiceId: mariadbService, PlanId: mariadbPlan})
+
+	url := "/v2/service_instances/" + instanceId + "/service_bindings/" + bindingId + "/last_operation"
+
+	if w := serve(a, http.MethodGet, url, ""); w.Code != http.StatusGone {
+		t.Error(red("operation of unknown binding not reported as gone"))
+	}
+
+	binding := &store.Binding{Id: bindingId, InstanceId: instanceId, ServiceId: mariadbService, PlanId: mariadbPlan}
+	operation := binding.StartOperation(store.OperationBind)
+	a.Store.SaveBinding(binding)
+
+	w := serve(a, http.MethodGet, url+"?operation="+operation.Id, "")
+
+	if w.Code != http.StatusOK {
+		t.Error(red("binding operation not returned: " + w.Body.String()))
+	}
+	if !strings.Contains(w.Body.String(), `"state":"in progress"`) {
+		t.Error(red("binding operation not in progress: " + w.Body.String()))
+	}
+
+	if w := serve(a, http.MethodGet, url+"?operation=unknown", ""); w.Code != http.StatusBadRequest {
+		t.Error(red("unknown operation not rejected"))
+	}
+
+	operation.State = store.StateSucceeded
+	a.Store.SaveBinding(binding)
+
+	if w := serve(a, http.MethodGet, url, ""); !strings.Contains(w.Body.String(), `"state":"succeeded"`) {
+		t.Error(red("last binding operation not succeeded: " + w.Body.String()))
+	}
+}
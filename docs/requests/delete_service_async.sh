@@ -1,3 +1,4 @@
 #!/bin/sh
 
-curl -i -X "DELETE" "http://localhost:5000/v2/service_instances/3b2e7d2c915242a5befcf03e1c3f47cd?accepts_incomplete=true"
+curl -i -X "DELETE" "http://localhost:5000/v2/service_instances/3b2e7d2c915242a5befcf03e1c3f47cd?accepts_incomplete=true" \
+     -H "X-Broker-API-Version: 2.13"
@@ -1,3 +1,4 @@
 #!/bin/sh
 
-curl -ss "http://localhost:5000/v2/catalog" | json_pp
+curl -ss "http://localhost:5000/v2/catalog" \
+     -H "X-Broker-API-Version: 2.13" | json_pp
@@ -0,0 +1,4 @@
+#!/bin/sh
+
+curl -ss "http://localhost:5000/v2/service_instances/3b2e7d2c915242a5befcf03e1c3f47cd/service_bindings/09a22eb6c23c4a33b074b7ef082a5759/last_operation" \
+     -H "X-Broker-API-Version: 2.13" | json_pp
@@ -0,0 +1,4 @@
+#!/bin/sh
+
+curl -ss "http://localhost:5000/v2/service_instances/3b2e7d2c915242a5befcf03e1c3f47cd/last_operation?operation=$1" \
+     -H "X-Broker-API-Version: 2.13" | json_pp
@@ -0,0 +1,6 @@
+#!/bin/sh
+
+curl -i -X "PATCH" "http://localhost:5000/v2/service_instances/3b2e7d2c915242a5befcf03e1c3f47cd?accepts_incomplete=true" \
+     -H "X-Broker-API-Version: 2.13" \
+     -H "Content-Type: application/json; charset=utf-8" \
+     -d $'{ "plan_id": "7b16d6aa-260a-4b8d-b12c-464d2cedb9d0", "service_id": "201cb950-e640-4453-9d91-4708ea0a1342", "previous_values": { "plan_id": "169d5466-12c9-4a89-a063-f72048b3d4c4" } }'
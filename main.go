@@ -1,14 +1,50 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"github.com/monostream/helmi/pkg/catalog"
+	"github.com/monostream/helmi/pkg/helm"
 	"os"
 	"path/filepath"
 )
 
 func main() {
-	a := App{}
+	// helm 3 runs helmi as post renderer to label the objects of a release
+	if helm.IsPostRender() {
+		if err := helm.PostRender(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	path, configured := os.LookupEnv("CATALOG_PATH")
+
+	if !configured {
+		path = "./catalog.yaml"
+	}
+
+	flag.StringVar(&path, "catalog", path, "catalog file or directory of service files")
+	flag.Parse()
+
+	flag.Visit(func(f *flag.Flag) {
+		configured = configured || f.Name == "catalog"
+	})
+
+	path, _ = filepath.Abs(path)
 
-	path, _ := filepath.Abs("./catalog.yaml")
+	// helmi validate [catalog.yaml] prints all problems of a catalog
+	if flag.Arg(0) == "validate" {
+		if len(flag.Arg(1)) > 0 {
+			path = flag.Arg(1)
+		}
+
+		os.Exit(validate(path))
+	}
+
+	a := App{}
 
 	port := os.Getenv("PORT")
 
@@ -16,6 +52,17 @@ func main() {
 		port = "5000"
 	}
 
-	a.Initialize(path)
+	a.Initialize(catalog.GetSources(path, configured))
 	a.Run(":" + port)
 }
+
+func validate(path string) int {
+	if _, err := catalog.Load(path); err != nil {
+		fmt.Fprintln(os.Stderr, path+":")
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println(path + " is valid")
+	return 0
+}
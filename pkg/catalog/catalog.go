@@ -1,26 +1,67 @@
 package catalog
 
 import (
+	"fmt"
 	"log"
+	"errors"
+	"regexp"
+	"strconv"
 	"strings"
-	"io/ioutil"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"gopkg.in/yaml.v2"
 )
 
 type Catalog struct {
 	Services []CatalogService `yaml:"services"`
+
+	Revision string `yaml:"-"`
+
+	documents []document
 }
 
+// document locates the services of a source document to report problems with their file and line
+type document struct {
+	name  string
+	first int
+	count int
+	lines map[string]int
+}
+
+var ErrServiceNotFound = errors.New("service not found")
+var ErrPlanNotFound = errors.New("plan not found")
+
 type CatalogService struct {
 	Id          string `yaml:"_id"`
 	Name        string `yaml:"_name"`
 	Description string `yaml:"description"`
 
+	Tags     []string `yaml:"tags"`
+	Requires []string `yaml:"requires"`
+	Bindable *bool    `yaml:"bindable"`
+
+	Metadata        *CatalogServiceMetadata `yaml:"metadata"`
+	DashboardClient *CatalogDashboardClient `yaml:"dashboard-client"`
+
+	PlanUpdatable bool `yaml:"plan-updateable"`
+	AsyncOnly     bool `yaml:"async-only"`
+
+	ReleaseName string `yaml:"release-name"`
+
 	Chart        string            `yaml:"chart"`
 	ChartVersion string            `yaml:"chart-version"`
 	ChartValues  map[string]string `yaml:"chart-values"`
 
 	UserCredentials map[string]interface{} `yaml:"user-credentials"`
+	UserParameters  map[string]string      `yaml:"user-parameters"`
+
+	// web console of an instance, resolved with the lookups of the user credentials
+	DashboardUrl string `yaml:"dashboard-url"`
+
+	Binding      *CatalogBinding      `yaml:"binding"`
+	Namespace    *CatalogNamespace    `yaml:"namespace"`
+	Restrictions *CatalogRestrictions `yaml:"restrictions"`
 
 	Plans []CatalogPlan `yaml:"plans"`
 }
@@ -30,29 +71,333 @@ type CatalogPlan struct {
 	Name        string `yaml:"_name"`
 	Description string `yaml:"description"`
 
+	Free     *bool                `yaml:"free"`
+	Bindable *bool                `yaml:"bindable"`
+	Metadata *CatalogPlanMetadata `yaml:"metadata"`
+
+	// seconds the platform polls the last operation of an instance
+	MaximumPollingDuration int `yaml:"maximum-polling-duration"`
+
+	AsyncOnly bool `yaml:"async-only"`
+
 	Chart        string            `yaml:"chart"`
 	ChartVersion string            `yaml:"chart-version"`
 	ChartValues  map[string]string `yaml:"chart-values"`
 
 	UserCredentials map[string]interface{} `yaml:"user-credentials"`
+	UserParameters  map[string]string      `yaml:"user-parameters"`
+
+	DashboardUrl string `yaml:"dashboard-url"`
+
+	Binding      *CatalogBinding      `yaml:"binding"`
+	Namespace    *CatalogNamespace    `yaml:"namespace"`
+	Restrictions *CatalogRestrictions `yaml:"restrictions"`
+
+	Schemas *CatalogSchemas `yaml:"schemas"`
+}
+
+// CatalogServiceMetadata is shown by the marketplace of the platform
+type CatalogServiceMetadata struct {
+	DisplayName         string `yaml:"display-name" json:"displayName,omitempty"`
+	ImageUrl            string `yaml:"image-url" json:"imageUrl,omitempty"`
+	LongDescription     string `yaml:"long-description" json:"longDescription,omitempty"`
+	ProviderDisplayName string `yaml:"provider-display-name" json:"providerDisplayName,omitempty"`
+	DocumentationUrl    string `yaml:"documentation-url" json:"documentationUrl,omitempty"`
+	SupportUrl          string `yaml:"support-url" json:"supportUrl,omitempty"`
+}
+
+type CatalogPlanMetadata struct {
+	DisplayName string        `yaml:"display-name" json:"displayName,omitempty"`
+	Bullets     []string      `yaml:"bullets" json:"bullets,omitempty"`
+	Costs       []CatalogCost `yaml:"costs" json:"costs,omitempty"`
+}
+
+// CatalogCost is the price of a plan per unit, the amount maps currency codes to values
+type CatalogCost struct {
+	Amount map[string]float64 `yaml:"amount" json:"amount"`
+	Unit   string             `yaml:"unit" json:"unit"`
+}
+
+// CatalogDashboardClient is the oauth client the platform registers for the dashboard of a service
+type CatalogDashboardClient struct {
+	Id          string `yaml:"id" json:"id"`
+	Secret      string `yaml:"secret" json:"secret"`
+	RedirectUri string `yaml:"redirect-uri" json:"redirect_uri,omitempty"`
+}
+
+// CatalogBinding declares jobs which create and revoke a dedicated user per binding
+type CatalogBinding struct {
+	Bind   *CatalogBindingAction `yaml:"bind"`
+	Unbind *CatalogBindingAction `yaml:"unbind"`
+}
+
+type CatalogBindingAction struct {
+	Image   string            `yaml:"image"`
+	Command []string          `yaml:"command"`
+	Env     map[string]string `yaml:"env"`
+	Timeout string            `yaml:"timeout"`
+}
+
+// CatalogNamespace selects the kubernetes namespace releases are installed to.
+// The strategy is one of fixed, instance or context.
+type CatalogNamespace struct {
+	Strategy string            `yaml:"strategy"`
+	Name     string            `yaml:"name"`
+	Prefix   string            `yaml:"prefix"`
+	Labels   map[string]string `yaml:"labels"`
+
+	ResourceQuota map[string]string   `yaml:"resource-quota"`
+	LimitRange    *CatalogLimitRange `yaml:"limit-range"`
+	NetworkPolicy bool               `yaml:"network-policy"`
+}
+
+// CatalogRestrictions limits provisioning to platforms and organizations of the osb context
+type CatalogRestrictions struct {
+	Platforms     []string `yaml:"platforms"`
+	Organizations []string `yaml:"organizations"`
+}
+
+// CatalogLimitRange sets container defaults and limits of created namespaces
+type CatalogLimitRange struct {
+	Default        map[string]string `yaml:"default"`
+	DefaultRequest map[string]string `yaml:"default-request"`
+	Max            map[string]string `yaml:"max"`
+}
+
+type CatalogSchemas struct {
+	ServiceInstance *CatalogServiceInstanceSchemas `yaml:"service_instance" json:"service_instance,omitempty"`
+	ServiceBinding  *CatalogServiceBindingSchemas  `yaml:"service_binding" json:"service_binding,omitempty"`
+}
+
+type CatalogServiceInstanceSchemas struct {
+	Create *CatalogSchema `yaml:"create" json:"create,omitempty"`
+	Update *CatalogSchema `yaml:"update" json:"update,omitempty"`
+}
+
+type CatalogServiceBindingSchemas struct {
+	Create *CatalogSchema `yaml:"create" json:"create,omitempty"`
+}
+
+type CatalogSchema struct {
+	Parameters map[string]interface{} `yaml:"parameters" json:"parameters"`
+}
+
+// UnmarshalYAML converts the schema into json compatible types
+func (s *CatalogSchema) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw struct {
+		Parameters interface{} `yaml:"parameters"`
+	}
+
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(convertYaml(raw.Parameters))
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &s.Parameters)
+}
+
+// IsBindable returns true unless the service disables bindings
+func (s CatalogService) IsBindable() bool {
+	return s.Bindable == nil || *s.Bindable
+}
+
+// IsBindable returns the setting of the plan, which overrides the one of the service
+func (p CatalogPlan) IsBindable(service CatalogService) bool {
+	if p.Bindable != nil {
+		return *p.Bindable
+	}
+
+	return service.IsBindable()
+}
+
+// IsFree returns the setting of the plan, plans with costs are not free by default
+func (p CatalogPlan) IsFree() bool {
+	if p.Free != nil {
+		return *p.Free
+	}
+
+	return p.Metadata == nil || len(p.Metadata.Costs) == 0
+}
+
+func (s *CatalogSchemas) GetInstanceCreateSchema() map[string]interface{} {
+	if s != nil && s.ServiceInstance != nil && s.ServiceInstance.Create != nil {
+		return s.ServiceInstance.Create.Parameters
+	}
+
+	return nil
+}
+
+func (s *CatalogSchemas) GetInstanceUpdateSchema() map[string]interface{} {
+	if s != nil && s.ServiceInstance != nil && s.ServiceInstance.Update != nil {
+		return s.ServiceInstance.Update.Parameters
+	}
+
+	return nil
+}
+
+func (s *CatalogSchemas) GetBindingCreateSchema() map[string]interface{} {
+	if s != nil && s.ServiceBinding != nil && s.ServiceBinding.Create != nil {
+		return s.ServiceBinding.Create.Parameters
+	}
+
+	return nil
+}
+
+func convertYaml(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+
+		for key, item := range v {
+			m[fmt.Sprint(key)] = convertYaml(item)
+		}
+
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+
+		for i, item := range v {
+			a[i] = convertYaml(item)
+		}
+
+		return a
+	}
+
+	return value
 }
 
 func (c *Catalog) Parse(path string) {
-	input, err := ioutil.ReadFile(path)
+	loaded, err := Load(path)
 
 	if err != nil {
-		log.Printf("Catalog.Read: #%v ", err)
+		log.Fatalf("Catalog: %v", err)
 	}
 
-	// insert fake root to allow parsing
-	data := "services:\n" + string(input)
-	input = []byte(data)
+	*c = *loaded
+}
+
+// Load reads and validates a catalog file or directory, the revision is a hash of its content
+func Load(path string) (*Catalog, error) {
+	return LoadSources(NewFileSource(path))
+}
 
-	err = yaml.Unmarshal(input, c)
+// LoadSources merges the services of all sources into one catalog
+func LoadSources(sources ...Source) (*Catalog, error) {
+	documents, err := readSources(sources)
 
 	if err != nil {
-		log.Fatalf("Catalog.Unmarshal: %v", err)
+		return nil, err
+	}
+
+	return parseDocuments(documents)
+}
+
+func parse(input []byte) (*Catalog, error) {
+	return parseDocuments([] Document{{Content: input}})
+}
+
+func parseDocuments(documents [] Document) (*Catalog, error) {
+	c := &Catalog{}
+	hash := sha256.New()
+
+	for index, d := range documents {
+		input := d.Content
+
+		// a document with a single service is indented as list, which keeps its line numbers
+		if !isServiceList(input) {
+			input = toServiceList(input)
+		}
+
+		var part Catalog
+
+		// insert fake root to allow parsing
+		data := "services:\n" + string(input)
+
+		if err := yaml.Unmarshal([]byte(data), &part); err != nil {
+			return nil, getSourceError(d.Name, err)
+		}
+
+		c.documents = append(c.documents, document{
+			name:  d.Name,
+			first: len(c.Services),
+			count: len(part.Services),
+			lines: indexLines(input),
+		})
+
+		c.Services = append(c.Services, part.Services...)
+
+		if index > 0 {
+			hash.Write([]byte{0})
+		}
+
+		hash.Write(d.Content)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	c.Revision = hex.EncodeToString(hash.Sum(nil))[:12]
+
+	return c, nil
+}
+
+// isServiceList returns true if the first content of the document is a sequence item
+func isServiceList(input []byte) bool {
+	for _, line := range strings.Split(string(input), "\n") {
+		content := strings.TrimSpace(line)
+
+		if len(content) == 0 || strings.HasPrefix(content, "#") || content == "---" {
+			continue
+		}
+
+		return strings.HasPrefix(content, "-")
 	}
+
+	return true
+}
+
+func toServiceList(input []byte) []byte {
+	lines := strings.Split(string(input), "\n")
+	first := true
+
+	for index, line := range lines {
+		content := strings.TrimSpace(line)
+
+		if len(content) == 0 || content == "---" {
+			lines[index] = ""
+			continue
+		}
+
+		if first && !strings.HasPrefix(content, "#") {
+			lines[index] = "- " + line
+			first = false
+			continue
+		}
+
+		lines[index] = "  " + line
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// getSourceError corrects the line numbers of yaml errors, which count the inserted root
+func getSourceError(name string, err error) error {
+	corrected := regexp.MustCompile(`line (\d+)`).ReplaceAllStringFunc(err.Error(), func(m string) string {
+		line, _ := strconv.Atoi(strings.TrimPrefix(m, "line "))
+		return "line " + strconv.Itoa(line-1)
+	})
+
+	if len(name) > 0 {
+		corrected = name + ": " + corrected
+	}
+
+	return errors.New(corrected)
 }
 
 func (c *Catalog) GetService(service string) (CatalogService, error) {
@@ -62,7 +407,7 @@ func (c *Catalog) GetService(service string) (CatalogService, error) {
 		}
 	}
 
-	return *new(CatalogService), nil
+	return *new(CatalogService), ErrServiceNotFound
 }
 
 func (c *Catalog) GetServicePlan(service string, plan string) (CatalogPlan, error) {
@@ -76,5 +421,5 @@ func (c *Catalog) GetServicePlan(service string, plan string) (CatalogPlan, erro
 		}
 	}
 
-	return *new(CatalogPlan), nil
+	return *new(CatalogPlan), ErrPlanNotFound
 }
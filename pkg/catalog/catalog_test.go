@@ -1,8 +1,13 @@
 package catalog
 
 import (
+	"os"
 	"testing"
 	"strconv"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+	"github.com/monostream/helmi/pkg/command"
 )
 
 const service string = "201cb950-e640-4453-9d91-4708ea0a1342"
@@ -24,6 +29,9 @@ func Test_GetService(t *testing.T) {
 	if cs.Name != "cassandra" {
 		t.Error(red("service name is wrong"))
 	}
+	if !cs.PlanUpdatable {
+		t.Error(red("service plan updateable is wrong"))
+	}
 }
 
 func Test_GetServicePlan(t *testing.T) {
@@ -35,4 +43,289 @@ func Test_GetServicePlan(t *testing.T) {
 	if value, _ := strconv.Atoi(csp.ChartValues["replicaCount"]); value != 1 {
 		t.Error(red("chart value in plan is wrong"))
 	}
-}
\ No newline at end of file
+}
+
+func Test_GetServicePlanSchemas(t *testing.T) {
+	csp, _ := c.GetServicePlan("c26e6c7a-fe17-4568-ac4c-46545ab1d178", "381c8dd1-676b-4d1f-ae00-97e8304f966f")
+
+	schema := csp.Schemas.GetInstanceCreateSchema()
+
+	if schema == nil || schema["type"] != "object" {
+		t.Error(red("instance create schema is wrong"))
+	}
+	if csp.Schemas.GetBindingCreateSchema() != nil {
+		t.Error(red("binding create schema is wrong"))
+	}
+
+	if _, ok := schema["properties"].(map[string]interface{}); !ok {
+		t.Error(red("schema properties are not json compatible"))
+	}
+}
+
+func Test_GetServiceBinding(t *testing.T) {
+	cs, _ := c.GetService("ab53df4d-c279-4880-94f7-65e7d72b7834")
+
+	if cs.Binding == nil || cs.Binding.Bind == nil || cs.Binding.Unbind == nil {
+		t.Fatal(red("service binding is missing"))
+	}
+	if cs.Binding.Bind.Image != "mariadb:10.1" || len(cs.Binding.Bind.Command) != 3 {
+		t.Error(red("service bind action is wrong"))
+	}
+}
+
+func Test_GetServiceNamespace(t *testing.T) {
+	cs, _ := c.GetService("ab53df4d-c279-4880-94f7-65e7d72b7834")
+
+	if cs.Namespace == nil || cs.Namespace.Strategy != "instance" {
+		t.Fatal(red("service namespace is missing"))
+	}
+	if cs.Namespace.ResourceQuota["requests.storage"] != "20Gi" || !cs.Namespace.NetworkPolicy {
+		t.Error(red("service namespace policies are wrong"))
+	}
+}
+
+func Test_GetServiceMetadata(t *testing.T) {
+	cs, _ := c.GetService("ab53df4d-c279-4880-94f7-65e7d72b7834")
+	csp, _ := c.GetServicePlan("ab53df4d-c279-4880-94f7-65e7d72b7834", "e79306ef-4e10-4e3d-b38e-ffce88c90f59")
+
+	if len(cs.Tags) != 3 || cs.Metadata == nil || cs.Metadata.DisplayName != "MariaDB" {
+		t.Error(red("service metadata is wrong"))
+	}
+	if csp.Metadata == nil || len(csp.Metadata.Bullets) != 1 || !csp.IsFree() || !csp.IsBindable(cs) {
+		t.Error(red("plan metadata is wrong"))
+	}
+
+	paid := CatalogPlan{Metadata: &CatalogPlanMetadata{Costs: []CatalogCost{{Amount: map[string]float64{"usd": 10}, Unit: "MONTHLY"}}}}
+	bindable := false
+
+	if paid.IsFree() || paid.IsBindable(CatalogService{Bindable: &bindable}) {
+		t.Error(red("plan with costs is free or bindable"))
+	}
+}
+
+const serviceA = "- _id: 0b1d6a4a-2a43-4bd6-9d84-4bd2a8a5d4a1\n  _name: a\n  chart: stable/a\n  plans:\n  - _id: 5f5e0cbb-9ad9-4e4a-8a34-7f7c3b5d0c11\n    _name: free\n"
+const serviceB = "- _id: 9e0f5b8e-58c4-4a8e-8f4c-0d8b6ba6a2b2\n  _name: b\n  chart: stable/b\n  plans:\n  - _id: 1c7d3b8e-8e61-4b6f-9d3d-55b0e1b3c6a3\n    _name: free\n"
+
+func Test_Validate(t *testing.T) {
+	if _, err := parse([]byte(serviceA + serviceB)); err != nil {
+		t.Error(red("valid catalog rejected: " + err.Error()))
+	}
+
+	duplicate := serviceA + strings.Replace(serviceB, "9e0f5b8e-58c4-4a8e-8f4c-0d8b6ba6a2b2", "0B1D6A4A-2A43-4BD6-9D84-4BD2A8A5D4A1", 1)
+
+	_, err := parse([]byte(duplicate))
+
+	if problems, ok := err.(ValidationError); !ok || len(problems) != 1 || problems[0].Line != 7 {
+		t.Error(red("duplicate id not reported with its line"))
+	}
+
+	missingChart := strings.Replace(serviceA, "  chart: stable/a\n", "", 1)
+
+	if _, err := parse([]byte(missingChart)); err == nil || err.Error() != "line 4: plan free of service a has no chart" {
+		t.Error(red("plan without chart not reported"))
+	}
+
+	if _, err := parse([]byte("- _id: x\n  _name: [broken\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Error(red("yaml error line is wrong"))
+	}
+}
+
+func Test_ValidateLookups(t *testing.T) {
+	lookups := serviceA + `  chart-values:
+    password: "{{ lookup('password', 'password') }}"
+    host: "{{ lookup('cluster', 'address') }}"
+    token: "{{ lookup('token', 'token', encoding=base32) }}"
+    tls.crt: "{{ lookup('tls', 'certificate') }}"
+  user-credentials:
+    password: "{{ lookup('password', 'password') }}"
+    database: "{{ lookup('value', 'database') }}"
+    port: "{{ lookup('cluster', 'port' }}"
+    hosts:
+    - "{{ lookup('release', 'name') }}"
+`
+
+	_, err := parse([]byte(lookups))
+
+	problems, ok := err.(ValidationError)
+
+	if !ok || len(problems) != 5 {
+		t.Fatal(red("lookup problems are wrong: " + err.Error()))
+	}
+	if problems[0].Line != 9 || !strings.Contains(problems[0].Message, `"cluster" is not available`) {
+		t.Error(red("unavailable lookup type not reported: " + problems[0].String()))
+	}
+	if problems[1].Line != 10 || !strings.Contains(problems[1].Message, "option encoding of lookup type token") {
+		t.Error(red("invalid lookup option not reported: " + problems[1].String()))
+	}
+	if problems[2].Line != 11 || !strings.Contains(problems[2].Message, "unknown tls value certificate") {
+		t.Error(red("unknown tls value not reported: " + problems[2].String()))
+	}
+	if problems[3].Line != 14 || !strings.Contains(problems[3].Message, "undefined chart value database") {
+		t.Error(red("undefined value not reported: " + problems[3].String()))
+	}
+	if problems[4].Line != 15 || !strings.Contains(problems[4].Message, "invalid template") {
+		t.Error(red("invalid template not reported: " + problems[4].String()))
+	}
+}
+
+func Test_ValidateMetadata(t *testing.T) {
+	metadata := serviceA + `  requires:
+  - volume_mount
+  - route_service
+  dashboard-client:
+    id: dashboard
+`
+
+	_, err := parse([]byte(metadata))
+
+	problems, ok := err.(ValidationError)
+
+	if !ok || len(problems) != 2 || problems[0].Line != 9 || problems[1].Line != 10 {
+		t.Error(red("metadata problems are wrong: " + err.Error()))
+	}
+}
+
+func Test_IndexLines(t *testing.T) {
+	lines := indexLines([]byte(serviceA + serviceB))
+
+	if lines["0/plans/0/_name"] != 6 || lines["1/_id"] != 7 || lines["1/plans/0"] != 11 {
+		t.Error(red("line index is wrong"))
+	}
+}
+
+func Test_GetUnknownService(t *testing.T) {
+	if _, err := c.GetService("unknown"); err != ErrServiceNotFound {
+		t.Error(red("unknown service not reported"))
+	}
+	if _, err := c.GetServicePlan(service, "unknown"); err != ErrPlanNotFound {
+		t.Error(red("unknown plan not reported"))
+	}
+}
+
+func Test_Watcher(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "catalog")
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "catalog.yaml")
+
+	ioutil.WriteFile(path, []byte(serviceA), 0644)
+
+	w, err := NewWatcher(NewFileSource(path))
+
+	if err != nil {
+		t.Fatal(red("failed to load catalog: " + err.Error()))
+	}
+
+	revision := w.Current().Revision
+
+	ioutil.WriteFile(path, []byte("- _id: a\n  _name: [broken\n"), 0644)
+
+	if w.Reload() == nil || w.Current().Revision != revision || len(w.Status().Error) == 0 {
+		t.Error(red("invalid catalog replaced the current catalog"))
+	}
+
+	ioutil.WriteFile(path, []byte(serviceA + serviceB), 0644)
+
+	if err := w.Reload(); err != nil || w.Current().Revision == revision || len(w.Current().Services) != 2 {
+		t.Error(red("changed catalog not reloaded"))
+	}
+	if len(w.Status().Error) != 0 {
+		t.Error(red("reload error not cleared"))
+	}
+}
+
+func Test_WatcherInvalidSource(t *testing.T) {
+	broken := `{"items": [{"metadata": {"name": "a", "namespace": "helmi"}, "data": {"service.yaml": "- _id: a\n  _name: [broken\n"}}]}`
+	services := `{"items": [{"metadata": {"name": "b", "namespace": "helmi"}, "spec": {"_id": "9e0f5b8e-58c4-4a8e-8f4c-0d8b6ba6a2b2", "_name": "b", "chart": "stable/b", "plans": [{"_id": "1c7d3b8e-8e61-4b6f-9d3d-55b0e1b3c6a3", "_name": "free"}]}}]}`
+
+	fake := &command.Fake{Results: []command.Result{{Stdout: broken}, {Stdout: services}}}
+
+	defer command.SetExecutor(command.SetExecutor(fake))
+
+	w, err := NewWatcher(NewConfigMapSource("helmi", "helmi/catalog"), NewCustomResourceSource("helmi", ""))
+
+	if err != nil {
+		t.Fatal(red("invalid source blocked the catalog: " + err.Error()))
+	}
+	if len(w.Current().Services) != 1 || w.Current().Services[0].Name != "b" {
+		t.Error(red("services of the valid source not loaded"))
+	}
+	if !strings.Contains(w.Status().Error, "configmap/helmi/a/service.yaml") {
+		t.Error(red("invalid source not reported: " + w.Status().Error))
+	}
+}
+
+func Test_WatcherUnreadableSource(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "catalog")
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "catalog.yaml")
+
+	ioutil.WriteFile(path, []byte(serviceA), 0644)
+
+	fake := &command.Fake{Results: []command.Result{{Stderr: "Error: connection refused", ExitCode: 1}}}
+
+	defer command.SetExecutor(command.SetExecutor(fake))
+
+	w, err := NewWatcher(NewFileSource(path), NewCustomResourceSource("helmi", ""))
+
+	if err != nil {
+		t.Fatal(red("unreadable source stopped the broker: " + err.Error()))
+	}
+	if len(w.Current().Services) != 1 || w.Current().Services[0].Name != "a" {
+		t.Error(red("catalog file not loaded"))
+	}
+	if len(w.Status().Error) == 0 {
+		t.Error(red("unreadable source not reported"))
+	}
+}
+
+func Test_LoadDirectory(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "catalog")
+	defer os.RemoveAll(directory)
+
+	// a single service without list item, indented like in a custom resource
+	single := strings.Replace(strings.Replace(serviceB, "\n  ", "\n", -1), "- _id", "_id", 1)
+
+	ioutil.WriteFile(filepath.Join(directory, "a.yaml"), []byte(serviceA), 0644)
+	ioutil.WriteFile(filepath.Join(directory, "b.yml"), []byte("# service b\n" + single), 0644)
+	ioutil.WriteFile(filepath.Join(directory, "README.md"), []byte("no service"), 0644)
+
+	loaded, err := Load(directory)
+
+	if err != nil {
+		t.Fatal(red("failed to load directory: " + err.Error()))
+	}
+	if len(loaded.Services) != 2 || loaded.Services[1].Name != "b" || len(loaded.Services[1].Plans) != 1 {
+		t.Error(red("services of directory not merged"))
+	}
+
+	ioutil.WriteFile(filepath.Join(directory, "b.yml"), []byte(strings.Replace(single, "chart: stable/b", "chart: ''", 1)), 0644)
+
+	_, err = Load(directory)
+
+	if problems, ok := err.(ValidationError); !ok || len(problems) != 1 || problems[0].File != filepath.Join(directory, "b.yml") || problems[0].Line != 5 {
+		t.Error(red("problem not reported with file and line: " + err.Error()))
+	}
+}
+
+func Test_KubernetesSources(t *testing.T) {
+	configMaps := `{"items": [{"metadata": {"name": "a", "namespace": "helmi"}, "data": {"service.yaml": "` + strings.Replace(serviceA, "\n", "\\n", -1) + `", "notes.txt": "ignored"}}]}`
+	services := `{"items": [{"metadata": {"name": "b", "namespace": "helmi"}, "spec": {"_id": "9e0f5b8e-58c4-4a8e-8f4c-0d8b6ba6a2b2", "_name": "b", "chart": "stable/b", "plans": [{"_id": "1c7d3b8e-8e61-4b6f-9d3d-55b0e1b3c6a3", "_name": "free"}]}}]}`
+
+	fake := &command.Fake{Results: []command.Result{{Stdout: configMaps}, {Stdout: services}}}
+
+	defer command.SetExecutor(command.SetExecutor(fake))
+
+	loaded, err := LoadSources(NewConfigMapSource("helmi", "helmi/catalog"), NewCustomResourceSource("helmi", ""))
+
+	if err != nil {
+		t.Fatal(red("failed to load kubernetes sources: " + err.Error()))
+	}
+	if len(loaded.Services) != 2 || loaded.Services[0].Name != "a" || loaded.Services[1].Plans[0].Name != "free" {
+		t.Error(red("services of kubernetes sources not merged"))
+	}
+	if len(fake.Commands) != 2 || fake.Commands[0] != "kubectl get configmap --output json --selector helmi/catalog --namespace helmi" || fake.Commands[1] != "kubectl get helmiservice --output json --namespace helmi" {
+		t.Error(red("kubernetes resources not listed"))
+	}
+}
@@ -0,0 +1,255 @@
+package catalog
+
+import (
+	"encoding/json"
+	"github.com/monostream/helmi/pkg/kubectl"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Document holds a list of services or a single service in the yaml format of catalog.yaml
+type Document struct {
+	Name    string
+	Content []byte
+}
+
+// Source provides the documents of a catalog, which are merged in their order
+type Source interface {
+	Read() ([]Document, error)
+	String() string
+}
+
+// custom resource of chart owners which publish a service, its spec is a service of the catalog
+const customResourceKind = "helmiservice"
+
+type fileSource struct {
+	path string
+}
+
+// NewFileSource reads a catalog file or all yaml files of a directory ordered by name
+func NewFileSource(path string) Source {
+	return fileSource{path: path}
+}
+
+func (s fileSource) String() string {
+	return s.path
+}
+
+func (s fileSource) Read() ([]Document, error) {
+	info, err := os.Stat(s.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		content, err := ioutil.ReadFile(s.path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return []Document{{Name: s.path, Content: content}}, nil
+	}
+
+	files, err := ioutil.ReadDir(s.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var documents []Document
+
+	for _, file := range files {
+		// mounted config maps contain hidden directories with the previous revisions
+		if strings.HasPrefix(file.Name(), ".") || !isYamlFile(file.Name()) {
+			continue
+		}
+
+		path := filepath.Join(s.path, file.Name())
+
+		content, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		documents = append(documents, Document{Name: path, Content: content})
+	}
+
+	return documents, nil
+}
+
+func isYamlFile(name string) bool {
+	extension := strings.ToLower(filepath.Ext(name))
+	return extension == ".yaml" || extension == ".yml"
+}
+
+type configMapSource struct {
+	namespace string
+	selector  string
+}
+
+// NewConfigMapSource reads the yaml keys of all config maps matching the label selector
+func NewConfigMapSource(namespace string, selector string) Source {
+	return configMapSource{namespace: namespace, selector: selector}
+}
+
+func (s configMapSource) String() string {
+	return "configmaps " + s.selector
+}
+
+func (s configMapSource) Read() ([]Document, error) {
+	output, err := kubectl.List(s.namespace, "configmap", s.selector)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Data map[string]string `json:"data"`
+		} `json:"items"`
+	}
+
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, err
+	}
+
+	var documents []Document
+
+	for _, item := range list.Items {
+		var keys []string
+
+		for key := range item.Data {
+			if isYamlFile(key) {
+				keys = append(keys, key)
+			}
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			documents = append(documents, Document{
+				Name:    "configmap/" + item.Metadata.Namespace + "/" + item.Metadata.Name + "/" + key,
+				Content: []byte(item.Data[key]),
+			})
+		}
+	}
+
+	sortDocuments(documents)
+
+	return documents, nil
+}
+
+type customResourceSource struct {
+	namespace string
+	selector  string
+}
+
+// NewCustomResourceSource reads the services of HelmiService resources
+func NewCustomResourceSource(namespace string, selector string) Source {
+	return customResourceSource{namespace: namespace, selector: selector}
+}
+
+func (s customResourceSource) String() string {
+	return "helmiservices " + s.selector
+}
+
+func (s customResourceSource) Read() ([]Document, error) {
+	output, err := kubectl.List(s.namespace, customResourceKind, s.selector)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Spec interface{} `json:"spec"`
+		} `json:"items"`
+	}
+
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, err
+	}
+
+	var documents []Document
+
+	for _, item := range list.Items {
+		// the spec is converted to block yaml, problems are reported with lines of the converted spec
+		content, err := yaml.Marshal(item.Spec)
+
+		if err != nil {
+			return nil, err
+		}
+
+		documents = append(documents, Document{
+			Name:    customResourceKind + "/" + item.Metadata.Namespace + "/" + item.Metadata.Name,
+			Content: content,
+		})
+	}
+
+	sortDocuments(documents)
+
+	return documents, nil
+}
+
+// sortDocuments keeps the order independent of the order returned by the api, which would change the revision
+func sortDocuments(documents []Document) {
+	sort.SliceStable(documents, func(a, b int) bool {
+		return documents[a].Name < documents[b].Name
+	})
+}
+
+// GetSources returns the file source of the path and the kubernetes sources configured by the label
+// selectors CATALOG_CONFIGMAPS and CATALOG_CUSTOM_RESOURCES, an empty selector reads all HelmiServices
+func GetSources(path string, required bool) []Source {
+	var sources []Source
+
+	namespace := os.Getenv("CATALOG_NAMESPACE")
+
+	// an empty selector would read every config map
+	if selector := os.Getenv("CATALOG_CONFIGMAPS"); len(selector) > 0 {
+		sources = append(sources, NewConfigMapSource(namespace, selector))
+	}
+
+	if selector, exists := os.LookupEnv("CATALOG_CUSTOM_RESOURCES"); exists {
+		sources = append(sources, NewCustomResourceSource(namespace, selector))
+	}
+
+	// the default catalog file is optional if services are read from kubernetes
+	if _, err := os.Stat(path); required || len(sources) == 0 || err == nil {
+		sources = append([]Source{NewFileSource(path)}, sources...)
+	}
+
+	return sources
+}
+
+// readSources reads the documents of all sources
+func readSources(sources []Source) ([]Document, error) {
+	var documents []Document
+
+	for _, source := range sources {
+		read, err := source.Read()
+
+		if err != nil {
+			return nil, err
+		}
+
+		documents = append(documents, read...)
+	}
+
+	return documents, nil
+}
@@ -0,0 +1,448 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/monostream/helmi/pkg/generator"
+	"github.com/monostream/helmi/pkg/template"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Problem is a mistake in the catalog, the line is 0 if it could not be located
+type Problem struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	message := p.Message
+
+	if p.Line > 0 {
+		message = "line " + strconv.Itoa(p.Line) + ": " + message
+	}
+
+	if len(p.File) > 0 {
+		message = p.File + ": " + message
+	}
+
+	return message
+}
+
+// ValidationError lists all problems of a catalog ordered by file and line
+type ValidationError []Problem
+
+func (e ValidationError) Error() string {
+	var messages []string
+
+	for _, p := range e {
+		messages = append(messages, p.String())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+var guidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+var placeholderRegex = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// lookup types which can be resolved when chart values are rendered, before the release exists
+var chartValueLookups = []string{"username", "password", "token", "key", "tls", "env", "context"}
+
+// lookup types which can be resolved for credentials and binding jobs of a running release
+var releaseLookups = []string{"value", "username", "password", "token", "key", "tls", "cluster", "release", "binding", "context"}
+
+// values of the tls lookup, the certificate of a release and its key are issued by the CA of the broker
+var tlsValues = []string{"ca", "cert", "key"}
+
+// lookup types of the dashboard url, which does not belong to a binding
+var dashboardLookups = []string{"value", "username", "password", "token", "key", "cluster", "release", "context"}
+
+var namePlaceholders = []string{"service", "plan", "id", "shortid"}
+
+var namespaceStrategies = []string{"fixed", "instance", "context"}
+
+// permissions a service may require from the platform
+var servicePermissions = []string{"syslog_drain", "route_forwarding", "volume_mount"}
+
+type validator struct {
+	documents []document
+	problems  ValidationError
+}
+
+// Validate checks ids, charts, lookups and the values referenced by credentials
+func (c *Catalog) Validate() error {
+	v := &validator{documents: c.documents}
+
+	ids := map[string]string{}
+
+	checkId := func(path string, id string, name string, kind string) {
+		if len(id) == 0 {
+			v.add(path, "%s %q requires an _id", kind, name)
+			return
+		}
+
+		if !guidRegex.MatchString(id) {
+			v.add(path+"/_id", "%s id %s is not a guid", kind, id)
+		}
+
+		if other, exists := ids[strings.ToLower(id)]; exists {
+			v.add(path+"/_id", "%s id %s is already used by %s", kind, id, other)
+		}
+
+		ids[strings.ToLower(id)] = kind + " " + name
+
+		if len(name) == 0 {
+			v.add(path, "%s %s requires a _name", kind, id)
+		}
+	}
+
+	for i, s := range c.Services {
+		servicePath := strconv.Itoa(i)
+
+		checkId(servicePath, s.Id, s.Name, "service")
+
+		if len(s.Plans) == 0 {
+			v.add(servicePath, "service %s has no plans", s.Name)
+		}
+
+		v.checkTemplates(servicePath+"/chart-values", stringMap(s.ChartValues), chartValueLookups, nil)
+		v.checkNamespace(servicePath+"/namespace", s.Namespace)
+		v.checkReleaseName(servicePath+"/release-name", s.ReleaseName)
+		v.checkMetadata(servicePath, s)
+
+		for j, p := range s.Plans {
+			planPath := servicePath + "/plans/" + strconv.Itoa(j)
+
+			checkId(planPath, p.Id, p.Name, "plan")
+
+			if len(s.Chart) == 0 && len(p.Chart) == 0 {
+				v.add(planPath, "plan %s of service %s has no chart", p.Name, s.Name)
+			}
+
+			v.checkTemplates(planPath+"/chart-values", stringMap(p.ChartValues), chartValueLookups, nil)
+			v.checkNamespace(planPath+"/namespace", p.Namespace)
+			v.checkCosts(planPath+"/metadata/costs", p.Metadata)
+
+			if p.MaximumPollingDuration < 0 {
+				v.add(planPath+"/maximum-polling-duration", "maximum polling duration of plan %s must not be negative", p.Name)
+			}
+
+			// credentials and jobs of a plan can only read the values of the service and the plan
+			values := map[string]bool{}
+
+			for _, chartValues := range []map[string]string{s.ChartValues, p.ChartValues, s.UserParameters, p.UserParameters} {
+				for key, value := range chartValues {
+					values[key] = true
+					values[value] = true
+				}
+			}
+
+			v.checkTemplates(servicePath+"/user-credentials", s.UserCredentials, releaseLookups, values)
+			v.checkTemplates(planPath+"/user-credentials", p.UserCredentials, releaseLookups, values)
+			v.checkTemplate(servicePath+"/dashboard-url", s.DashboardUrl, dashboardLookups, values)
+			v.checkTemplate(planPath+"/dashboard-url", p.DashboardUrl, dashboardLookups, values)
+			v.checkBinding(servicePath+"/binding", s.Binding, values)
+			v.checkBinding(planPath+"/binding", p.Binding, values)
+		}
+	}
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(v.problems, func(a, b int) bool {
+		if v.problems[a].File != v.problems[b].File {
+			return v.getOrder(v.problems[a].File) < v.getOrder(v.problems[b].File)
+		}
+
+		return v.problems[a].Line < v.problems[b].Line
+	})
+
+	return v.problems
+}
+
+// add records a problem once, plans repeat the checks of the service templates
+func (v *validator) add(path string, format string, arguments ...interface{}) {
+	file, line := v.locate(path)
+
+	problem := Problem{File: file, Line: line, Message: fmt.Sprintf(format, arguments...)}
+
+	for _, p := range v.problems {
+		if p == problem {
+			return
+		}
+	}
+
+	v.problems = append(v.problems, problem)
+}
+
+// locate returns the document of the service the path starts with and the line of the path
+func (v *validator) locate(path string) (string, int) {
+	parts := strings.SplitN(path, "/", 2)
+	index, _ := strconv.Atoi(parts[0])
+
+	for _, d := range v.documents {
+		if index < d.first || index >= d.first+d.count {
+			continue
+		}
+
+		// paths are indexed relative to the document
+		parts[0] = strconv.Itoa(index - d.first)
+
+		return d.name, getLine(d.lines, strings.Join(parts, "/"))
+	}
+
+	return "", 0
+}
+
+// getOrder returns the position of a document in the catalog
+func (v *validator) getOrder(name string) int {
+	for index, d := range v.documents {
+		if d.name == name {
+			return index
+		}
+	}
+
+	return len(v.documents)
+}
+
+// getLine returns the line of the path or of its closest parent
+func getLine(lines map[string]int, path string) int {
+	for len(path) > 0 {
+		if line, exists := lines[path]; exists {
+			return line
+		}
+
+		index := strings.LastIndex(path, "/")
+
+		if index < 0 {
+			break
+		}
+
+		path = path[:index]
+	}
+
+	return lines[path]
+}
+
+func (v *validator) checkTemplates(path string, templates map[string]interface{}, lookupTypes []string, values map[string]bool) {
+	for key, template := range templates {
+		switch t := template.(type) {
+		case string:
+			v.checkTemplate(path+"/"+key, t, lookupTypes, values)
+		case []interface{}:
+			for index, item := range t {
+				if s, ok := item.(string); ok {
+					v.checkTemplate(path+"/"+key+"/"+strconv.Itoa(index), s, lookupTypes, values)
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) checkTemplate(path string, text string, lookupTypes []string, values map[string]bool) {
+	calls, err := template.Parse(path[strings.Index(path, "/")+1:], text)
+
+	if err != nil {
+		v.add(path, "invalid template: %v", err)
+		return
+	}
+
+	for _, call := range calls {
+		lookupType := strings.ToLower(call.Type)
+
+		if !contains(lookupTypes, lookupType) {
+			v.add(path, "lookup type %q is not available here, use one of %s", call.Type, strings.Join(lookupTypes, ", "))
+			continue
+		}
+
+		if err := generator.Check(lookupType, call.Options); err != nil {
+			v.add(path, "invalid lookup of %s %s: %v", call.Type, call.Path, err)
+		}
+
+		if lookupType == "tls" && !contains(tlsValues, call.Path) {
+			v.add(path, "unknown tls value %s, use one of %s", call.Path, strings.Join(tlsValues, ", "))
+		}
+
+		if values == nil {
+			continue
+		}
+
+		switch lookupType {
+		case "value", "username", "password", "token", "key":
+			if !values[call.Path] {
+				v.add(path, "lookup of %s reads the undefined chart value %s", call.Type, call.Path)
+			}
+		}
+	}
+}
+
+func (v *validator) checkBinding(path string, binding *CatalogBinding, values map[string]bool) {
+	if binding == nil {
+		return
+	}
+
+	for name, action := range map[string]*CatalogBindingAction{"bind": binding.Bind, "unbind": binding.Unbind} {
+		if action == nil {
+			continue
+		}
+
+		actionPath := path + "/" + name
+
+		if len(action.Image) == 0 {
+			v.add(actionPath, "%s job requires an image", name)
+		}
+
+		var command []interface{}
+
+		for _, c := range action.Command {
+			command = append(command, c)
+		}
+
+		v.checkTemplates(actionPath, map[string]interface{}{"command": command}, releaseLookups, values)
+		v.checkTemplates(actionPath+"/env", stringMap(action.Env), releaseLookups, values)
+	}
+}
+
+func (v *validator) checkNamespace(path string, namespace *CatalogNamespace) {
+	if namespace == nil {
+		return
+	}
+
+	if !contains(namespaceStrategies, strings.ToLower(namespace.Strategy)) {
+		v.add(path+"/strategy", "unknown namespace strategy %q, use one of %s", namespace.Strategy, strings.Join(namespaceStrategies, ", "))
+	}
+
+	if strings.EqualFold(namespace.Strategy, "fixed") && len(namespace.Name) == 0 {
+		v.add(path, "fixed namespace requires a name")
+	}
+}
+
+func (v *validator) checkMetadata(path string, service CatalogService) {
+	for index, permission := range service.Requires {
+		if !contains(servicePermissions, permission) {
+			v.add(path+"/requires/"+strconv.Itoa(index), "unknown permission %q, use one of %s", permission, strings.Join(servicePermissions, ", "))
+		}
+	}
+
+	if client := service.DashboardClient; client != nil && (len(client.Id) == 0 || len(client.Secret) == 0) {
+		v.add(path+"/dashboard-client", "dashboard client of service %s requires an id and a secret", service.Name)
+	}
+}
+
+func (v *validator) checkCosts(path string, metadata *CatalogPlanMetadata) {
+	if metadata == nil {
+		return
+	}
+
+	for index, cost := range metadata.Costs {
+		if len(cost.Amount) == 0 || len(cost.Unit) == 0 {
+			v.add(path+"/"+strconv.Itoa(index), "costs require an amount and a unit")
+		}
+	}
+}
+
+func (v *validator) checkReleaseName(path string, template string) {
+	for _, placeholder := range placeholderRegex.FindAllStringSubmatch(template, -1) {
+		if !contains(namePlaceholders, strings.ToLower(placeholder[1])) {
+			v.add(path, "unknown placeholder %s in release name", placeholder[0])
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func stringMap(values map[string]string) map[string]interface{} {
+	m := map[string]interface{}{}
+
+	for key, value := range values {
+		m[key] = value
+	}
+
+	return m
+}
+
+// indexLines maps the paths of a block style yaml document to their line numbers,
+// keys are separated by slashes and sequence items are numbered like "0/plans/1/chart"
+func indexLines(input []byte) map[string]int {
+	type frame struct {
+		indent int
+		path   string
+		isItem bool
+	}
+
+	lines := map[string]int{}
+	items := map[string]int{}
+	stack := []frame{{indent: -1}}
+
+	join := func(parent string, key string) string {
+		if len(parent) == 0 {
+			return key
+		}
+
+		return parent + "/" + key
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	number := 0
+
+	for scanner.Scan() {
+		number++
+
+		line := scanner.Text()
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+
+		if len(strings.TrimSpace(content)) == 0 || strings.HasPrefix(content, "#") {
+			continue
+		}
+
+		// sequence items, the content after the dash is a key of the item
+		for content == "-" || strings.HasPrefix(content, "- ") {
+			for len(stack) > 1 && (stack[len(stack)-1].indent > indent || (stack[len(stack)-1].isItem && stack[len(stack)-1].indent == indent)) {
+				stack = stack[:len(stack)-1]
+			}
+
+			parent := stack[len(stack)-1].path
+			path := join(parent, strconv.Itoa(items[parent]))
+			items[parent]++
+
+			lines[path] = number
+			stack = append(stack, frame{indent: indent, path: path, isItem: true})
+
+			trimmed := strings.TrimLeft(strings.TrimPrefix(content, "-"), " ")
+			indent += len(content) - len(trimmed)
+			content = trimmed
+		}
+
+		separator := strings.Index(content, ":")
+
+		if len(content) == 0 || separator < 0 || (separator+1 < len(content) && content[separator+1] != ' ') {
+			continue
+		}
+
+		for len(stack) > 1 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		key := strings.Trim(content[:separator], "\"'")
+		path := join(stack[len(stack)-1].path, key)
+
+		lines[path] = number
+		stack = append(stack, frame{indent: indent, path: path})
+	}
+
+	return lines
+}
@@ -0,0 +1,223 @@
+package catalog
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Watcher reloads the catalog when the content of its sources changes. Requests read the current catalog,
+// which is replaced as a whole, so a request never sees a partially loaded catalog.
+type Watcher struct {
+	sources []Source
+	current atomic.Value
+
+	mutex     sync.Mutex
+	content   []byte
+	valid     map[string]Document
+	rejected  error
+	loaded    time.Time
+	lastError error
+}
+
+// WatcherStatus describes the loaded catalog and the last failed reload or the rejected documents
+type WatcherStatus struct {
+	Sources  []string  `json:"sources"`
+	Revision string    `json:"revision"`
+	Services int       `json:"services"`
+	Loaded   time.Time `json:"loaded"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// NewWatcher loads the catalog without its rejected documents. If a kubernetes source can not be read at
+// start, the catalog files are loaded alone until a reload reads all sources.
+func NewWatcher(sources ...Source) (*Watcher, error) {
+	w := &Watcher{sources: sources}
+
+	err := w.Reload()
+
+	if !w.loaded.IsZero() {
+		return w, nil
+	}
+
+	log.Printf("Catalog: %v, starting with the catalog files", err)
+
+	var files []Source
+
+	for _, source := range sources {
+		if _, ok := source.(fileSource); ok {
+			files = append(files, source)
+		}
+	}
+
+	documents, fileErr := readSources(files)
+
+	if fileErr != nil {
+		return nil, fileErr
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if rejected := w.load(documents); rejected == nil {
+		w.lastError = err
+	}
+
+	return w, nil
+}
+
+// Current returns the catalog to use for a request
+func (w *Watcher) Current() *Catalog {
+	return w.current.Load().(*Catalog)
+}
+
+// Reload parses the sources if they changed, a source which can not be read keeps the current catalog.
+// The returned error lists the rejected documents, the catalog is loaded without them.
+func (w *Watcher) Reload() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	documents, err := readSources(w.sources)
+
+	if err != nil {
+		w.lastError = err
+
+		if !w.loaded.IsZero() {
+			log.Printf("Catalog: keeping revision %s, reload failed: %v", w.Current().Revision, err)
+		}
+
+		return err
+	}
+
+	return w.load(documents)
+}
+
+// load validates the documents one by one. A document which is invalid on its own or together with the
+// documents before it keeps its last valid content or is left out, so a broken service of one chart owner
+// does not block the services of the others.
+func (w *Watcher) load(documents []Document) error {
+	input := getContent(documents)
+
+	// rejected documents are only reported once
+	if !w.loaded.IsZero() && bytes.Equal(input, w.content) {
+		w.lastError = w.rejected
+		return w.rejected
+	}
+
+	var accepted []Document
+	var problems []string
+
+	for _, d := range documents {
+		err := checkDocument(accepted, d)
+
+		if err == nil {
+			accepted = append(accepted, d)
+			continue
+		}
+
+		problems = append(problems, err.Error())
+
+		if previous, ok := w.valid[d.Name]; ok && checkDocument(accepted, previous) == nil {
+			accepted = append(accepted, previous)
+
+			log.Printf("Catalog: keeping the previous content of %s, it is invalid: %v", d.Name, err)
+			continue
+		}
+
+		log.Printf("Catalog: leaving out %s, it is invalid: %v", d.Name, err)
+	}
+
+	c, err := parseDocuments(accepted)
+
+	if err != nil {
+		w.lastError = err
+		return err
+	}
+
+	w.valid = map[string]Document{}
+
+	for _, d := range accepted {
+		w.valid[d.Name] = d
+	}
+
+	w.rejected = nil
+
+	if len(problems) > 0 {
+		w.rejected = errors.New(strings.Join(problems, "\n"))
+	}
+
+	w.content = input
+	w.loaded = time.Now()
+	w.lastError = w.rejected
+	w.current.Store(c)
+
+	log.Printf("Catalog: loaded revision %s with %d services", c.Revision, len(c.Services))
+
+	return w.rejected
+}
+
+// Watch polls the sources in the interval until stop is closed, mounted config maps are replaced
+// through symlinks, so the content is compared instead of relying on file events
+func (w *Watcher) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Reload()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (w *Watcher) Status() WatcherStatus {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	c := w.Current()
+
+	var sources []string
+
+	for _, source := range w.sources {
+		sources = append(sources, source.String())
+	}
+
+	status := WatcherStatus{
+		Sources:  sources,
+		Revision: c.Revision,
+		Services: len(c.Services),
+		Loaded:   w.loaded,
+	}
+
+	if w.lastError != nil {
+		status.Error = w.lastError.Error()
+	}
+
+	return status
+}
+
+// checkDocument returns the problems of a document merged with the accepted documents
+func checkDocument(accepted []Document, d Document) error {
+	_, err := parseDocuments(append(accepted[:len(accepted):len(accepted)], d))
+	return err
+}
+
+// getContent joins the names and contents of the documents to detect changes
+func getContent(documents []Document) []byte {
+	var content bytes.Buffer
+
+	for _, d := range documents {
+		content.WriteString(d.Name)
+		content.WriteByte(0)
+		content.Write(d.Content)
+		content.WriteByte(0)
+	}
+
+	return content.Bytes()
+}
@@ -0,0 +1,324 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/monostream/helmi/pkg/generator"
+	"github.com/monostream/helmi/pkg/kubectl"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const authorityName = "helmi"
+const authorityValidity = 10 * 365 * 24 * time.Hour
+const leafValidity = 825 * 24 * time.Hour
+
+// certificates expiring within this duration are issued again when a release is updated
+const renewBefore = 30 * 24 * time.Hour
+
+// Authority is the CA of the broker which issues the certificates of releases
+type Authority struct {
+	// Certificate and Key of the CA in PEM format
+	Certificate string
+	Key         string
+
+	certificate *x509.Certificate
+	key         crypto.Signer
+}
+
+var authority *Authority
+var mutex sync.Mutex
+
+// Configure loads the CA from the tls secret TLS_CA_SECRET in TLS_CA_NAMESPACE. A missing secret is
+// created with a new CA, without a secret the CA is generated on first use and lost on restart.
+func Configure() error {
+	name := os.Getenv("TLS_CA_SECRET")
+
+	if len(name) == 0 {
+		return nil
+	}
+
+	namespace := os.Getenv("TLS_CA_NAMESPACE")
+
+	data, err := kubectl.GetSecret(namespace, name)
+
+	if err != nil {
+		return err
+	}
+
+	var a *Authority
+
+	if data != nil {
+		a, err = Load(data["tls.crt"], data["tls.key"])
+	} else {
+		a, err = New()
+
+		if err == nil {
+			err = saveSecret(namespace, name, a)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	authority = a
+
+	return nil
+}
+
+// GetAuthority returns the configured CA or generates one
+func GetAuthority() (*Authority, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if authority == nil {
+		a, err := New()
+
+		if err != nil {
+			return nil, err
+		}
+
+		authority = a
+	}
+
+	return authority, nil
+}
+
+// New generates a self signed CA
+func New() (*Authority, error) {
+	keyPem, err := generator.PrivateKey(nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parseKey(keyPem)
+
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	ca := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: authorityName, Organization: []string{authorityName}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(authorityValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, ca, ca, key.Public(), key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Load(encodeCertificate(der), keyPem)
+}
+
+// Load reads a CA and its key in PEM format
+func Load(certificatePem string, keyPem string) (*Authority, error) {
+	certificate, err := parseCertificate(certificatePem)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !certificate.IsCA {
+		return nil, errors.New("certificate " + certificate.Subject.CommonName + " is not a CA")
+	}
+
+	key, err := parseKey(keyPem)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isKeyOf(certificate, key) {
+		return nil, errors.New("key does not belong to the CA " + certificate.Subject.CommonName)
+	}
+
+	return &Authority{
+		Certificate: certificatePem,
+		Key:         keyPem,
+		certificate: certificate,
+		key:         key,
+	}, nil
+}
+
+// Issue creates a server certificate for the hosts, which are dns names or ip addresses, and its key in PEM format
+func (a *Authority) Issue(hosts []string) (string, string, error) {
+	if len(hosts) == 0 {
+		return "", "", errors.New("certificate requires a host")
+	}
+
+	keyPem, err := generator.PrivateKey(nil)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	key, err := parseKey(keyPem)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	serial, err := newSerial()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+
+	leaf := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(leafValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			leaf.IPAddresses = append(leaf.IPAddresses, ip)
+		} else {
+			leaf.DNSNames = append(leaf.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, leaf, a.certificate, key.Public(), a.key)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return encodeCertificate(der), keyPem, nil
+}
+
+// IsValid returns true if a certificate was issued by the CA for all hosts, belongs to the key and does not expire soon
+func (a *Authority) IsValid(certificatePem string, keyPem string, hosts []string) bool {
+	certificate, err := parseCertificate(certificatePem)
+
+	if err != nil || certificate.CheckSignatureFrom(a.certificate) != nil {
+		return false
+	}
+
+	if time.Now().Add(renewBefore).After(certificate.NotAfter) {
+		return false
+	}
+
+	key, err := parseKey(keyPem)
+
+	if err != nil || !isKeyOf(certificate, key) {
+		return false
+	}
+
+	for _, host := range hosts {
+		if certificate.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// saveSecret stores the CA as tls secret, so it is loaded again after a restart
+func saveSecret(namespace string, name string, a *Authority) error {
+	secret := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "kubernetes.io/tls",
+		"metadata": map[string]interface{}{
+			"name": name,
+		},
+		"data": map[string]string{
+			"tls.crt": base64.StdEncoding.EncodeToString([]byte(a.Certificate)),
+			"tls.key": base64.StdEncoding.EncodeToString([]byte(a.Key)),
+		},
+	}
+
+	if len(namespace) > 0 {
+		secret["metadata"].(map[string]interface{})["namespace"] = namespace
+	}
+
+	manifest, err := json.Marshal(secret)
+
+	if err != nil {
+		return err
+	}
+
+	return kubectl.Apply(namespace, manifest)
+}
+
+func parseCertificate(certificatePem string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certificatePem))
+
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("certificate is not in PEM format")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseKey(keyPem string) (crypto.Signer, error) {
+	key, err := generator.ParsePrivateKey(keyPem)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+
+	if !ok {
+		return nil, errors.New("unsupported private key")
+	}
+
+	return signer, nil
+}
+
+func isKeyOf(certificate *x509.Certificate, key crypto.Signer) bool {
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+
+	if err != nil {
+		return false
+	}
+
+	certificatePublic, err := x509.MarshalPKIXPublicKey(certificate.PublicKey)
+
+	return err == nil && bytes.Equal(public, certificatePublic)
+}
+
+func encodeCertificate(der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,67 @@
+package certificate
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func red(msg string) string {
+	return "\033[31m" + msg + "\033[39m\n\n"
+}
+
+func Test_Issue(t *testing.T) {
+	a, err := New()
+
+	if err != nil {
+		t.Fatal(red("failed to create CA: " + err.Error()))
+	}
+
+	hosts := []string{"helmi-mariadb.default.svc.cluster.local", "10.0.0.1"}
+
+	certificatePem, keyPem, err := a.Issue(hosts)
+
+	if err != nil {
+		t.Fatal(red("failed to issue certificate: " + err.Error()))
+	}
+
+	certificate, _ := parseCertificate(certificatePem)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(a.Certificate))
+
+	for _, host := range hosts {
+		if _, err := certificate.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Error(red("certificate not valid for " + host + ": " + err.Error()))
+		}
+	}
+
+	if !a.IsValid(certificatePem, keyPem, hosts) {
+		t.Error(red("issued certificate not valid"))
+	}
+	if a.IsValid(certificatePem, keyPem, []string{"other.default.svc.cluster.local"}) {
+		t.Error(red("certificate valid for other host"))
+	}
+
+	other, _ := New()
+
+	if other.IsValid(certificatePem, keyPem, hosts) {
+		t.Error(red("certificate valid for other CA"))
+	}
+}
+
+func Test_Load(t *testing.T) {
+	a, _ := New()
+	other, _ := New()
+
+	if _, err := Load(a.Certificate, a.Key); err != nil {
+		t.Error(red("failed to load CA: " + err.Error()))
+	}
+	if _, err := Load(a.Certificate, other.Key); err == nil {
+		t.Error(red("key of other CA not reported"))
+	}
+
+	leaf, key, _ := a.Issue([]string{"host"})
+
+	if _, err := Load(leaf, key); err == nil {
+		t.Error(red("certificate which is not a CA not reported"))
+	}
+}
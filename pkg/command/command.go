@@ -0,0 +1,56 @@
+package command
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+)
+
+// Executor runs an external command and returns its combined output
+type Executor interface {
+	Run(input []byte, env []string, name string, arguments ...string) ([]byte, error)
+}
+
+// Exec runs commands as processes
+type Exec struct {
+}
+
+func (e Exec) Run(input []byte, env []string, name string, arguments ...string) ([]byte, error) {
+	cmd := exec.Command(name, arguments...)
+
+	// the process inherits the environment of helmi
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+
+	return cmd.CombinedOutput()
+}
+
+var executor Executor = Exec{}
+
+// SetExecutor replaces the executor of all commands and returns the previous one
+func SetExecutor(e Executor) Executor {
+	previous := executor
+	executor = e
+
+	return previous
+}
+
+// Run executes a command with the current executor
+func Run(name string, arguments ...string) ([]byte, error) {
+	return executor.Run(nil, nil, name, arguments...)
+}
+
+// RunWithInput executes a command and passes input on stdin
+func RunWithInput(input []byte, name string, arguments ...string) ([]byte, error) {
+	return executor.Run(input, nil, name, arguments...)
+}
+
+// RunWithEnv executes a command with additional environment variables in the form key=value
+func RunWithEnv(env []string, name string, arguments ...string) ([]byte, error) {
+	return executor.Run(nil, env, name, arguments...)
+}
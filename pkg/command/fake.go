@@ -0,0 +1,44 @@
+package command
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Result is the scripted outcome of a command
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Fake replays scripted results in order and records the commands it was asked to run
+type Fake struct {
+	Results []Result
+
+	Commands []string
+	Inputs   [][]byte
+	Envs     [][]string
+}
+
+func (f *Fake) Run(input []byte, env []string, name string, arguments ...string) ([]byte, error) {
+	f.Commands = append(f.Commands, strings.Join(append([]string{name}, arguments...), " "))
+	f.Inputs = append(f.Inputs, input)
+	f.Envs = append(f.Envs, env)
+
+	if len(f.Results) == 0 {
+		return nil, errors.New("no result scripted for " + name)
+	}
+
+	result := f.Results[0]
+	f.Results = f.Results[1:]
+
+	output := []byte(result.Stdout + result.Stderr)
+
+	if result.ExitCode != 0 {
+		return output, errors.New("exit status " + strconv.Itoa(result.ExitCode))
+	}
+
+	return output, nil
+}
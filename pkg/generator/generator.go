@@ -0,0 +1,383 @@
+package generator
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Options of a generated value, e.g. length=24 or charset=alnum+symbols
+type Options map[string]string
+
+const defaultLength = 32
+const defaultCharset = "hex"
+const defaultBits = 2048
+
+// characters of the charsets, symbols leave out quotes, backslashes and characters of urls and shells
+var characters = map[string]string{
+	"lower":   "abcdefghijklmnopqrstuvwxyz",
+	"upper":   "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digits":  "0123456789",
+	"hex":     "0123456789abcdef",
+	"symbols": "!#%*+-.?@^_~",
+}
+
+// charsets which combine several classes of characters, each class is contained in a generated value
+var charsets = map[string][]string{
+	"lower":   {"lower"},
+	"upper":   {"upper"},
+	"digits":  {"digits"},
+	"hex":     {"hex"},
+	"symbols": {"symbols"},
+	"alpha":   {"lower", "upper"},
+	"alnum":   {"lower", "upper", "digits"},
+}
+
+// options of the lookup types, the first value of an option with values is the default
+var lookupOptions = map[string]map[string][]string{
+	"username": {"length": nil, "charset": nil, "prefix": nil},
+	"password": {"length": nil, "charset": nil, "prefix": nil},
+	"token":    {"length": nil, "encoding": {"hex", "base64", "base64url"}},
+	"key":      {"type": {"rsa", "ed25519"}, "bits": nil, "part": {"private", "public"}, "format": {"pem", "ssh"}},
+}
+
+// Check returns an error if an option is unknown to the lookup type or its value is invalid
+func Check(lookupType string, options Options) error {
+	known, ok := lookupOptions[strings.ToLower(lookupType)]
+
+	if !ok {
+		if len(options) > 0 {
+			return errors.New("lookup type " + lookupType + " has no options")
+		}
+
+		return nil
+	}
+
+	for name, value := range options {
+		values, ok := known[name]
+
+		if !ok {
+			return errors.New("unknown option " + name + " of lookup type " + lookupType)
+		}
+
+		if values != nil && !contains(values, value) {
+			return fmt.Errorf("option %s of lookup type %s must be one of %s", name, lookupType, strings.Join(values, ", "))
+		}
+	}
+
+	switch strings.ToLower(lookupType) {
+	case "username", "password":
+		_, _, err := getStringOptions(options)
+		return err
+	case "token":
+		_, err := getLength(options, defaultLength)
+		return err
+	case "key":
+		_, err := getBits(options)
+		return err
+	}
+
+	return nil
+}
+
+// String generates a username or password of the length, charset and prefix of the options,
+// which contains at least one character of every class of its charset
+func String(options Options) (string, error) {
+	length, classes, err := getStringOptions(options)
+
+	if err != nil {
+		return "", err
+	}
+
+	prefix := options["prefix"]
+
+	var all string
+	var generated []byte
+
+	for _, class := range classes {
+		all += characters[class]
+
+		c, err := randomCharacter(characters[class])
+
+		if err != nil {
+			return "", err
+		}
+
+		generated = append(generated, c)
+	}
+
+	for len(generated) < length-len(prefix) {
+		c, err := randomCharacter(all)
+
+		if err != nil {
+			return "", err
+		}
+
+		generated = append(generated, c)
+	}
+
+	// the required characters are moved to random positions
+	for i := len(generated) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+
+		if err != nil {
+			return "", err
+		}
+
+		generated[i], generated[j] = generated[j], generated[i]
+	}
+
+	return prefix + string(generated), nil
+}
+
+// Token generates random bytes of the length of the options encoded as hex or base64
+func Token(options Options) (string, error) {
+	length, err := getLength(options, defaultLength)
+
+	if err != nil {
+		return "", err
+	}
+
+	token := make([]byte, length)
+
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	switch options["encoding"] {
+	case "base64":
+		return base64.StdEncoding.EncodeToString(token), nil
+	case "base64url":
+		return base64.RawURLEncoding.EncodeToString(token), nil
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// PrivateKey generates a rsa or ed25519 key in PEM format
+func PrivateKey(options Options) (string, error) {
+	if options["type"] == "ed25519" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+
+		if err != nil {
+			return "", err
+		}
+
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+
+		if err != nil {
+			return "", err
+		}
+
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+	}
+
+	bits, err := getBits(options)
+
+	if err != nil {
+		return "", err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})), nil
+}
+
+// PublicKey returns the public key of a private key in PEM format or the authorized_keys format of ssh
+func PublicKey(privateKey string, options Options) (string, error) {
+	key, err := ParsePrivateKey(privateKey)
+
+	if err != nil {
+		return "", err
+	}
+
+	var public interface{}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		public = &k.PublicKey
+	case ed25519.PrivateKey:
+		public = k.Public()
+	default:
+		return "", errors.New("unsupported private key")
+	}
+
+	if options["format"] == "ssh" {
+		return getAuthorizedKey(public), nil
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// ParsePrivateKey reads a private key in the PEM format returned by PrivateKey
+func ParsePrivateKey(privateKey string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+
+	if block == nil {
+		return nil, errors.New("private key is not in PEM format")
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// getAuthorizedKey encodes a public key like a line of authorized_keys
+func getAuthorizedKey(public interface{}) string {
+	var keyType string
+	var fields [][]byte
+
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		keyType = "ssh-rsa"
+		fields = [][]byte{getMpint(big.NewInt(int64(k.E))), getMpint(k.N)}
+	case ed25519.PublicKey:
+		keyType = "ssh-ed25519"
+		fields = [][]byte{k}
+	}
+
+	data := getSshString([]byte(keyType))
+
+	for _, field := range fields {
+		data = append(data, getSshString(field)...)
+	}
+
+	return keyType + " " + base64.StdEncoding.EncodeToString(data)
+}
+
+func getSshString(value []byte) []byte {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(value)))
+	return append(length, value...)
+}
+
+// getMpint returns a positive integer of ssh, which starts with a zero byte if its first bit is set
+func getMpint(value *big.Int) []byte {
+	bytes := value.Bytes()
+
+	if len(bytes) > 0 && bytes[0]&0x80 != 0 {
+		bytes = append([]byte{0}, bytes...)
+	}
+
+	return bytes
+}
+
+func getStringOptions(options Options) (int, []string, error) {
+	length, err := getLength(options, defaultLength)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	charset := options["charset"]
+
+	if len(charset) == 0 {
+		charset = defaultCharset
+	}
+
+	var classes []string
+
+	for _, name := range strings.Split(charset, "+") {
+		charsetClasses, ok := charsets[strings.TrimSpace(name)]
+
+		if !ok {
+			return 0, nil, errors.New("unknown charset " + name)
+		}
+
+		for _, class := range charsetClasses {
+			if !contains(classes, class) {
+				classes = append(classes, class)
+			}
+		}
+	}
+
+	if length-len(options["prefix"]) < len(classes) {
+		return 0, nil, fmt.Errorf("length %d leaves no room for the prefix and the %d classes of charset %s", length, len(classes), charset)
+	}
+
+	return length, classes, nil
+}
+
+func getLength(options Options, fallback int) (int, error) {
+	value, ok := options["length"]
+
+	if !ok {
+		return fallback, nil
+	}
+
+	length, err := strconv.Atoi(value)
+
+	if err != nil || length < 1 || length > 1024 {
+		return 0, errors.New("length must be a number between 1 and 1024")
+	}
+
+	return length, nil
+}
+
+func getBits(options Options) (int, error) {
+	value, ok := options["bits"]
+
+	if !ok {
+		return defaultBits, nil
+	}
+
+	bits, err := strconv.Atoi(value)
+
+	if err != nil || bits < 2048 || bits > 8192 {
+		return 0, errors.New("bits must be a number between 2048 and 8192")
+	}
+
+	return bits, nil
+}
+
+func randomCharacter(characters string) (byte, error) {
+	i, err := randomInt(len(characters))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return characters[i], nil
+}
+
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n.Int64()), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,15 +1,13 @@
 package helm
 
 import (
-	"bufio"
-	"bytes"
-	"strings"
-	"strconv"
-	"os/exec"
-	"github.com/kylelemons/go-gypsy/yaml"
-	"time"
 	"os"
+	"sort"
+	"time"
 	"errors"
+	"strings"
+	"github.com/kylelemons/go-gypsy/yaml"
+	"github.com/monostream/helmi/pkg/kubectl"
 )
 
 type Status struct {
@@ -18,251 +16,173 @@ type Status struct {
 	IsFailed   bool
 	IsDeployed bool
 
+	// revision of the release whose resources are reported
+	Revision int
+
 	DesiredNodes int
 	AvailableNodes int
 
 	NodePorts map[int] int
 	ClusterPorts map[int] int
-}
 
-func Exists(release string) (bool, error) {
-	cmd := exec.Command("helm", "status", release)
-	output, err := cmd.CombinedOutput()
+	// hosts of the ingress rules of the release
+	IngressHosts [] string
+
+	Resources [] Resource
+}
 
-	if err == nil && len(output) > 0 {
-		return true, nil
+// IsReady returns true if all resources of the release are ready
+func (s Status) IsReady() bool {
+	for _, r := range s.Resources {
+		if !r.IsReady {
+			return false
+		}
 	}
 
-	if output != nil && len(output) > 0 {
-		text := string(output)
+	return s.AvailableNodes >= s.DesiredNodes
+}
 
-		if strings.Contains(strings.ToLower(text), "not found") {
-			return false, nil
+// hasFailedResource returns true if a resource of the release can not become ready anymore
+func (s Status) hasFailedResource() bool {
+	for _, r := range s.Resources {
+		if r.IsFailed {
+			return true
 		}
 	}
 
-	return false, err
+	return false
 }
 
-func Install(release string, chart string, version string, values map[string]string, acceptsIncomplete bool) (error) {
-	arguments := [] string{}
+// Backend runs helm commands for a specific major version of helm
+type Backend interface {
+	Exists(release string, namespace string) (bool, error)
+	Install(release string, namespace string, chart string, version string, values map[string]string, metadata Metadata, acceptsIncomplete bool) error
+	Upgrade(release string, namespace string, chart string, version string, values map[string]string, metadata Metadata, acceptsIncomplete bool) error
+	Delete(release string, namespace string) error
+	GetValues(release string, namespace string) (map[string]string, error)
+	GetStatus(release string, namespace string) (Status, error)
+}
 
-	arguments = append(arguments, "install", chart)
-	arguments = append(arguments, "--name", release)
+var backend Backend = helm3{Namespace: "default"}
 
-	if len(version) > 0 {
-		arguments = append(arguments, "--version", version)
-	}
+// Configure selects the backend by the HELM_VERSION environment variable, helm 3 is the default.
+// The kubernetes client has to be configured before.
+func Configure() error {
+	version, _ := os.LookupEnv("HELM_VERSION")
 
-	if acceptsIncomplete == false {
-		arguments = append(arguments, "--wait")
-	}
+	switch strings.TrimPrefix(strings.ToLower(version), "v") {
+	case "2":
+		backend = helm2{}
+		return nil
+	case "", "3":
+		namespace, exists := os.LookupEnv("HELM_NAMESPACE")
 
-	for key, value := range values {
-		arguments = append(arguments, "--set", key+"="+strings.Replace(value, ",", "\\,", -1))
-	}
+		if !exists {
+			namespace = "default"
+		}
 
-	cmd := exec.Command("helm", arguments...)
-	output, err := cmd.CombinedOutput()
+		// releases can only be read directly from the default secrets storage
+		driver, _ := os.LookupEnv("HELM_DRIVER")
+		native := kubectl.IsNative() && (driver == "" || strings.HasPrefix(strings.ToLower(driver), "secret"))
 
-	if err != nil {
-		return errors.New(string(output[:]))
+		backend = helm3{Namespace: namespace, Native: native}
+		return nil
 	}
 
-	return nil
+	return errors.New("unsupported helm version " + version)
 }
 
-func Delete(release string) error {
-	cmd := exec.Command("helm", "delete", release, "--purge")
-	output, err := cmd.CombinedOutput()
-
-	if err != nil {
-		return errors.New(string(output[:]))
-	}
-
-	return nil
+func Exists(release string, namespace string) (bool, error) {
+	return backend.Exists(release, namespace)
 }
 
-func GetValues(release string) (map[string]string, error) {
-	cmd := exec.Command("helm", "get", "values", release, "--all")
-	output, err := cmd.CombinedOutput()
-
-	if err != nil {
-		return nil, err
-	}
-
-	node, err := yaml.Parse(bytes.NewReader(output))
+func Install(release string, namespace string, chart string, version string, values map[string]string, metadata Metadata, acceptsIncomplete bool) (error) {
+	return backend.Install(release, namespace, chart, version, values, metadata, acceptsIncomplete)
+}
 
-	if err != nil {
-		return nil, err
-	}
+func Upgrade(release string, namespace string, chart string, version string, values map[string]string, metadata Metadata, acceptsIncomplete bool) (error) {
+	return backend.Upgrade(release, namespace, chart, version, values, metadata, acceptsIncomplete)
+}
 
-	properties := readYamlProperties(node, "")
-	return properties, err
+func Delete(release string, namespace string) error {
+	return backend.Delete(release, namespace)
 }
 
-func GetStatus(release string) (Status, error) {
-	cmd := exec.Command("helm", "status", release)
-	output, err := cmd.CombinedOutput()
+func GetValues(release string, namespace string) (map[string]string, error) {
+	return backend.GetValues(release, namespace)
+}
 
-	status := Status{
-		DesiredNodes: 0,
-		AvailableNodes: 0,
+func GetStatus(release string, namespace string) (Status, error) {
+	return backend.GetStatus(release, namespace)
+}
 
-		NodePorts:    map[int]int{},
-		ClusterPorts: map[int]int{},
+// GetNamespace returns the namespace a release is installed to, releases without a namespace
+// are installed to HELM_NAMESPACE with helm 3 and to the default namespace otherwise
+func GetNamespace(namespace string) string {
+	if len(namespace) > 0 {
+		return namespace
 	}
 
-	if err != nil {
-		return status, err
+	if h, ok := backend.(helm3); ok {
+		return h.getNamespace(namespace)
 	}
 
-	scanner := bufio.NewScanner(bytes.NewReader(output))
-
-	const StatusFailed = "STATUS: FAILED"
-	const StatusDeployed = "STATUS: DEPLOYED"
-
-	const ResourcePrefix = "==> "
-
-	const NamespacePrefix = "NAMESPACE: "
-	const DeploymentTimePrefix = "LAST DEPLOYED: "
-
-	const DesiredLabel = "DESIRED"
-	const CurrentLabel = "CURRENT"
-	const AvailableLabel = "AVAILABLE"
-	const PortsLabel = "PORT(S)"
-
-	var lastResource string
-	var lastDeploymentTime time.Time
-
-	columnDesired := -1
-	columnCurrent := -1
-	columnAvailable := -1
-	columnPort := -1
-
-	// our name
-	status.Name = release
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		if strings.HasPrefix(line, StatusFailed) {
-			status.IsFailed = true
-		}
-
-		if strings.HasPrefix(line, StatusDeployed) {
-			status.IsDeployed = true
-		}
-
-		if len(line) == 0 {
-			lastResource = ""
-
-			columnDesired = -1
-			columnCurrent = -1
-			columnAvailable = -1
-			columnPort = -1
-		}
+	return "default"
+}
 
-		if strings.HasPrefix(line, ResourcePrefix) {
-			lastResource = strings.TrimPrefix(line, ResourcePrefix)
-		}
+// getSetArguments passes values sorted by key as strings, so passwords and parameters keep their type.
+// Backslashes and commas are escaped, otherwise a value could end its assignment and set other keys.
+func getSetArguments(values map[string]string) [] string {
+	var keys [] string
 
-		// namespace
-		if strings.HasPrefix(line, NamespacePrefix) {
-			status.Namespace = strings.TrimPrefix(line, NamespacePrefix)
-		}
-
-		// deployment time
-		if strings.HasPrefix(line, DeploymentTimePrefix) {
-			loc, _ := time.LoadLocation("Local")
-			lastDeploymentTime, _ = time.ParseInLocation(time.ANSIC, strings.TrimPrefix(line, DeploymentTimePrefix), loc)
-		}
+	for key := range values {
+		keys = append(keys, key)
+	}
 
-		indexDesired := strings.Index(line, DesiredLabel)
-		indexCurrent := strings.Index(line, CurrentLabel)
-		indexAvailable := strings.Index(line, AvailableLabel)
-		indexPort := strings.Index(line, PortsLabel)
+	sort.Strings(keys)
 
-		if indexDesired >= 0 && indexCurrent >= 0 {
-			columnDesired = indexDesired
-			columnCurrent = indexCurrent
+	arguments := [] string{}
 
-			if indexAvailable >= 0 {
-				columnAvailable = indexAvailable
-			}
-		} else {
-			if columnDesired >= 0 && columnCurrent >= 0 {
-				nodesDesired := 0
-				nodesAvailable := 0
+	for _, key := range keys {
+		value := strings.Replace(values[key], "\\", "\\\\", -1)
+		value = strings.Replace(value, ",", "\\,", -1)
 
-				desired, desiredErr := strconv.Atoi(strings.Fields(line[columnDesired:])[0])
-				current, currentErr := strconv.Atoi(strings.Fields(line[columnCurrent:])[0])
+		arguments = append(arguments, "--set-string", key+"="+value)
+	}
 
-				if desiredErr == nil {
-					nodesDesired = desired
-				}
+	return arguments
+}
 
-				if currentErr == nil {
-					nodesAvailable = current
-				}
+// isTimedOut returns true if a release is still not available after the TIMEOUT since its deployment
+func isTimedOut(lastDeploymentTime time.Time, status Status) bool {
+	timeout, exists := os.LookupEnv("TIMEOUT")
+	if !exists {
+		timeout = "30m"
+	}
+	duration, _ := time.ParseDuration(timeout)
 
-				if columnAvailable >= 0 {
-					available, availableErr := strconv.Atoi(strings.Fields(line[columnAvailable:])[0])
+	return time.Now().After(lastDeploymentTime.Add(duration)) && !status.IsReady()
+}
 
-					if availableErr == nil {
-						nodesAvailable = available
-					}
-				}
+// readStatus completes the status of a release with the objects of its manifest
+func readStatus(status Status, manifest string, lastDeploymentTime time.Time) (Status, error) {
+	resources, err := parseManifest(manifest)
 
-				status.DesiredNodes += nodesDesired
-				status.AvailableNodes += nodesAvailable
-			}
-		}
+	if err != nil {
+		return status, err
+	}
 
-		if indexPort >= 0 {
-			columnPort = indexPort
-		} else {
-			if columnPort >= 0 {
-				for _, portPair := range strings.Split(strings.Fields(line[columnPort:])[0], ",") {
-					portFields := strings.FieldsFunc(portPair, func(c rune) bool {
-						return c == ':' || c == '/'
-					})
-
-					if len(portFields) == 2 {
-						clusterPort, clusterPortErr := strconv.Atoi(portFields[0])
-
-						if clusterPortErr == nil {
-							status.ClusterPorts[clusterPort] = clusterPort
-						}
-					}
-
-					if len(portFields) == 3 {
-						nodePort, nodePortErr := strconv.Atoi(portFields[1])
-						clusterPort, clusterPortErr := strconv.Atoi(portFields[0])
-
-						if nodePortErr == nil && clusterPortErr == nil {
-							status.NodePorts[clusterPort] = nodePort
-							status.ClusterPorts[clusterPort] = clusterPort
-						}
-					}
-				}
-			}
-		}
+	err = readResourceStatus(status.Namespace, resources, &status)
 
-		_ = lastResource
+	if err != nil {
+		return status, err
 	}
 
-	// timeout
-	timeout, exists := os.LookupEnv("TIMEOUT")
-	if !exists {
-		timeout = "30m"
-	}
-	duration, _ := time.ParseDuration(timeout)
-	if time.Now().After(lastDeploymentTime.Add(duration)) && status.AvailableNodes < status.DesiredNodes {
+	if status.hasFailedResource() || isTimedOut(lastDeploymentTime, status) {
 		status.IsFailed = true
 	}
 
-	return status, err
+	return status, nil
 }
 
 func readYamlProperties(node yaml.Node, prefix string) map[string]string {
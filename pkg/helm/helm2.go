@@ -0,0 +1,180 @@
+package helm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/kylelemons/go-gypsy/yaml"
+	"github.com/monostream/helmi/pkg/command"
+	"strings"
+	"time"
+)
+
+// helm 2 release status codes, see hapi.release.Status
+const statusCodeDeployed = 1
+const statusCodeFailed = 4
+
+// helm2 runs the helm 2 cli against tiller
+type helm2 struct {
+}
+
+type release2 struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
+
+	Info struct {
+		Status struct {
+			Code int `json:"code"`
+		} `json:"status"`
+
+		LastDeployed struct {
+			Seconds int64 `json:"seconds"`
+			Nanos   int64 `json:"nanos"`
+		} `json:"last_deployed"`
+	} `json:"info"`
+}
+
+func (h helm2) Exists(release string, namespace string) (bool, error) {
+	output, err := command.Run("helm", "status", release)
+
+	if err == nil && len(output) > 0 {
+		return true, nil
+	}
+
+	if output != nil && len(output) > 0 {
+		text := string(output)
+
+		if strings.Contains(strings.ToLower(text), "not found") {
+			return false, nil
+		}
+	}
+
+	return false, err
+}
+
+func (h helm2) Install(release string, namespace string, chart string, version string, values map[string]string, metadata Metadata, acceptsIncomplete bool) error {
+	arguments := []string{}
+
+	arguments = append(arguments, "install", chart)
+	arguments = append(arguments, "--name", release)
+
+	if len(namespace) > 0 {
+		arguments = append(arguments, "--namespace", namespace)
+	}
+
+	if len(version) > 0 {
+		arguments = append(arguments, "--version", version)
+	}
+
+	if acceptsIncomplete == false {
+		arguments = append(arguments, "--wait")
+	}
+
+	arguments = append(arguments, getSetArguments(values)...)
+
+	output, err := command.Run("helm", arguments...)
+
+	if err != nil {
+		return errors.New(string(output[:]))
+	}
+
+	return nil
+}
+
+func (h helm2) Upgrade(release string, namespace string, chart string, version string, values map[string]string, metadata Metadata, acceptsIncomplete bool) error {
+	arguments := []string{}
+
+	arguments = append(arguments, "upgrade", release, chart)
+
+	if len(version) > 0 {
+		arguments = append(arguments, "--version", version)
+	}
+
+	if acceptsIncomplete == false {
+		arguments = append(arguments, "--wait")
+	}
+
+	arguments = append(arguments, getSetArguments(values)...)
+
+	output, err := command.Run("helm", arguments...)
+
+	if err != nil {
+		return errors.New(string(output[:]))
+	}
+
+	return nil
+}
+
+func (h helm2) Delete(release string, namespace string) error {
+	output, err := command.Run("helm", "delete", release, "--purge")
+
+	if err != nil {
+		return errors.New(string(output[:]))
+	}
+
+	return nil
+}
+
+func (h helm2) GetValues(release string, namespace string) (map[string]string, error) {
+	output, err := command.Run("helm", "get", "values", release, "--all")
+
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := yaml.Parse(bytes.NewReader(output))
+
+	if err != nil {
+		return nil, err
+	}
+
+	properties := readYamlProperties(node, "")
+	return properties, err
+}
+
+func (h helm2) GetStatus(release string, namespace string) (Status, error) {
+	output, err := command.Run("helm", "status", release, "--output", "json")
+
+	if err != nil {
+		return Status{}, errors.New(string(output[:]))
+	}
+
+	status, lastDeploymentTime, err := parseStatus2(output)
+
+	if err != nil {
+		return status, err
+	}
+
+	manifest, err := command.Run("helm", "get", "manifest", release)
+
+	if err != nil {
+		return status, errors.New(string(manifest[:]))
+	}
+
+	return readStatus(status, string(manifest), lastDeploymentTime)
+}
+
+// parseStatus2 reads the output of `helm status --output json`
+func parseStatus2(output []byte) (Status, time.Time, error) {
+	var release release2
+
+	status := Status{
+		NodePorts:    map[int]int{},
+		ClusterPorts: map[int]int{},
+	}
+
+	if err := json.Unmarshal(output, &release); err != nil {
+		return status, time.Time{}, err
+	}
+
+	status.Name = release.Name
+	status.Namespace = release.Namespace
+	status.Revision = release.Version
+	status.IsDeployed = release.Info.Status.Code == statusCodeDeployed
+	status.IsFailed = release.Info.Status.Code == statusCodeFailed
+
+	lastDeploymentTime := time.Unix(release.Info.LastDeployed.Seconds, release.Info.LastDeployed.Nanos)
+
+	return status, lastDeploymentTime, nil
+}
@@ -0,0 +1,377 @@
+package helm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/monostream/helmi/pkg/command"
+	"github.com/monostream/helmi/pkg/kubectl"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// helm 3 release states, see `helm status --help`
+const statusDeployed = "deployed"
+const statusFailed = "failed"
+
+// helm3 runs the helm 3 cli, releases live in the namespace they are installed to.
+// With a native kubernetes client releases are read from the helm storage secrets instead,
+// installs, upgrades and uninstalls always run the cli, an in process backend with the Helm SDK
+// is out of scope as long as helmi does not vendor it.
+type helm3 struct {
+	Namespace string
+	Native    bool
+}
+
+type release3 struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Manifest  string `json:"manifest"`
+	Version   int    `json:"version"`
+
+	Info struct {
+		Status       string    `json:"status"`
+		LastDeployed time.Time `json:"last_deployed"`
+	} `json:"info"`
+
+	Chart struct {
+		Values map[string]interface{} `json:"values"`
+	} `json:"chart"`
+
+	Config map[string]interface{} `json:"config"`
+}
+
+func (h helm3) Exists(release string, namespace string) (bool, error) {
+	namespace = h.getNamespace(namespace)
+
+	if h.Native {
+		stored, err := h.getRelease(release, namespace)
+		return stored != nil, err
+	}
+
+	output, err := command.Run("helm", "status", release, "--namespace", namespace)
+
+	if err == nil && len(output) > 0 {
+		return true, nil
+	}
+
+	if isNotFound3(output) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+func (h helm3) Install(release string, namespace string, chart string, version string, values map[string]string, metadata Metadata, acceptsIncomplete bool) error {
+	namespace = h.getNamespace(namespace)
+
+	arguments := h.getArguments([]string{"install", release, chart}, namespace, version, values, acceptsIncomplete)
+
+	postRenderArguments, env, err := getPostRenderArguments(metadata)
+
+	if err != nil {
+		return err
+	}
+
+	output, err := command.RunWithEnv(env, "helm", append(arguments, postRenderArguments...)...)
+
+	if err != nil {
+		return errors.New(string(output[:]))
+	}
+
+	return nil
+}
+
+func (h helm3) Upgrade(release string, namespace string, chart string, version string, values map[string]string, metadata Metadata, acceptsIncomplete bool) error {
+	namespace = h.getNamespace(namespace)
+
+	arguments := h.getArguments([]string{"upgrade", release, chart}, namespace, version, values, acceptsIncomplete)
+
+	postRenderArguments, env, err := getPostRenderArguments(metadata)
+
+	if err != nil {
+		return err
+	}
+
+	output, err := command.RunWithEnv(env, "helm", append(arguments, postRenderArguments...)...)
+
+	if err != nil {
+		return errors.New(string(output[:]))
+	}
+
+	return nil
+}
+
+func (h helm3) Delete(release string, namespace string) error {
+	namespace = h.getNamespace(namespace)
+
+	output, err := command.Run("helm", "uninstall", release, "--namespace", namespace)
+
+	if err != nil {
+		return errors.New(string(output[:]))
+	}
+
+	return nil
+}
+
+func (h helm3) GetValues(release string, namespace string) (map[string]string, error) {
+	namespace = h.getNamespace(namespace)
+
+	if h.Native {
+		stored, err := h.getRelease(release, namespace)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if stored == nil {
+			return nil, errors.New("release " + release + " not found")
+		}
+
+		return readJsonProperties(mergeValues(stored.Chart.Values, stored.Config), ""), nil
+	}
+
+	output, err := command.Run("helm", "get", "values", release, "--all", "--output", "json", "--namespace", namespace)
+
+	if err != nil {
+		return nil, errors.New(string(output[:]))
+	}
+
+	return parseValues3(output)
+}
+
+func (h helm3) GetStatus(release string, namespace string) (Status, error) {
+	namespace = h.getNamespace(namespace)
+
+	if h.Native {
+		stored, err := h.getRelease(release, namespace)
+
+		if err != nil {
+			return Status{}, err
+		}
+
+		if stored == nil {
+			return Status{}, errors.New("release " + release + " not found")
+		}
+
+		return readStatus(stored.getStatus(), stored.Manifest, stored.Info.LastDeployed)
+	}
+
+	output, err := command.Run("helm", "status", release, "--output", "json", "--namespace", namespace)
+
+	if err != nil {
+		return Status{}, errors.New(string(output[:]))
+	}
+
+	status, manifest, lastDeploymentTime, err := parseStatus3(output)
+
+	if err != nil {
+		return status, err
+	}
+
+	// helm 3 does not report resources, they are read from the cluster
+	return readStatus(status, manifest, lastDeploymentTime)
+}
+
+// getNamespace defaults to the configured namespace
+func (h helm3) getNamespace(namespace string) string {
+	if len(namespace) == 0 {
+		return h.Namespace
+	}
+
+	return namespace
+}
+
+func (h helm3) getArguments(arguments []string, namespace string, version string, values map[string]string, acceptsIncomplete bool) []string {
+	arguments = append(arguments, "--namespace", namespace)
+
+	if len(version) > 0 {
+		arguments = append(arguments, "--version", version)
+	}
+
+	if acceptsIncomplete == false {
+		arguments = append(arguments, "--wait")
+	}
+
+	arguments = append(arguments, getSetArguments(values)...)
+
+	return arguments
+}
+
+func isNotFound3(output []byte) bool {
+	return strings.Contains(strings.ToLower(string(output)), "not found")
+}
+
+// parseStatus3 reads the output of `helm status --output json`
+func parseStatus3(output []byte) (Status, string, time.Time, error) {
+	var release release3
+
+	if err := json.Unmarshal(output, &release); err != nil {
+		return Status{}, "", time.Time{}, err
+	}
+
+	return release.getStatus(), release.Manifest, release.Info.LastDeployed, nil
+}
+
+func (r release3) getStatus() Status {
+	return Status{
+		Name:       r.Name,
+		Namespace:  r.Namespace,
+		Revision:   r.Version,
+		IsDeployed: strings.EqualFold(r.Info.Status, statusDeployed),
+		IsFailed:   strings.EqualFold(r.Info.Status, statusFailed),
+
+		NodePorts:    map[int]int{},
+		ClusterPorts: map[int]int{},
+	}
+}
+
+// getRelease reads the latest revision of a release from its storage secrets, nil if it does not exist
+func (h helm3) getRelease(release string, namespace string) (*release3, error) {
+	output, err := kubectl.List(namespace, "secret", "owner=helm,name="+release)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseReleaseSecrets3(output)
+}
+
+// parseReleaseSecrets3 decodes the latest release of a list of helm storage secrets
+func parseReleaseSecrets3(output []byte) (*release3, error) {
+	var secrets struct {
+		Items []struct {
+			Metadata struct {
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+
+			Data map[string]string `json:"data"`
+		} `json:"items"`
+	}
+
+	if err := json.Unmarshal(output, &secrets); err != nil {
+		return nil, err
+	}
+
+	latest := -1
+	latestVersion := 0
+
+	for index, secret := range secrets.Items {
+		version, err := strconv.Atoi(secret.Metadata.Labels["version"])
+
+		if err == nil && version > latestVersion {
+			latest = index
+			latestVersion = version
+		}
+	}
+
+	if latest < 0 {
+		return nil, nil
+	}
+
+	return decodeRelease3(secrets.Items[latest].Data["release"])
+}
+
+// decodeRelease3 decodes the release of a storage secret, helm stores it as base64 encoded gzipped json
+func decodeRelease3(data string) (*release3, error) {
+	encoded, err := base64.StdEncoding.DecodeString(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(string(encoded))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(decoded, []byte{0x1f, 0x8b, 0x08}) {
+		reader, err := gzip.NewReader(bytes.NewReader(decoded))
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer reader.Close()
+
+		if decoded, err = ioutil.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+
+	var release release3
+
+	if err := json.Unmarshal(decoded, &release); err != nil {
+		return nil, err
+	}
+
+	return &release, nil
+}
+
+// mergeValues overrides the default values of a chart with the user supplied values like `helm get values --all`
+func mergeValues(values map[string]interface{}, overrides map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+
+	for key, value := range values {
+		merged[key] = value
+	}
+
+	for key, value := range overrides {
+		valueMap, valueIsMap := merged[key].(map[string]interface{})
+		overrideMap, overrideIsMap := value.(map[string]interface{})
+
+		if valueIsMap && overrideIsMap {
+			merged[key] = mergeValues(valueMap, overrideMap)
+			continue
+		}
+
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// parseValues3 flattens the output of `helm get values --output json` to dotted keys
+func parseValues3(output []byte) (map[string]string, error) {
+	values := map[string]interface{}{}
+
+	if err := json.Unmarshal(output, &values); err != nil {
+		return nil, err
+	}
+
+	return readJsonProperties(values, ""), nil
+}
+
+func readJsonProperties(node interface{}, prefix string) map[string]string {
+	values := map[string]string{}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for mapKey, mapNode := range n {
+			nodeName := prefix
+
+			if len(nodeName) > 0 {
+				nodeName += "."
+			}
+
+			nodeName += mapKey
+
+			for key, value := range readJsonProperties(mapNode, nodeName) {
+				values[key] = value
+			}
+		}
+	case string:
+		values[prefix] = n
+	case float64:
+		values[prefix] = strconv.FormatFloat(n, 'f', -1, 64)
+	case bool:
+		values[prefix] = strconv.FormatBool(n)
+	}
+
+	return values
+}
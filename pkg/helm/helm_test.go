@@ -1,41 +1,519 @@
 package helm
 
 import (
-	"os"
-	"os/exec"
+	"strings"
 	"testing"
+	"io/ioutil"
+	"path/filepath"
+	"github.com/monostream/helmi/pkg/command"
 )
 
 func red(msg string) (string){
 	return "\033[31m" + msg + "\033[39m\n\n"
 }
 
-func helperCommand(command string, args ...string) *exec.Cmd {
-	cs := []string{"-test.run=TestHelperProcess", "--", command}
-	cs = append(cs, args...)
-	cmd := exec.Command(os.Args[0], cs...)
-	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
-	return cmd
-}
+// useFake scripts the results of the next helm and kubectl commands
+func useFake(results ...command.Result) (*command.Fake, func()) {
+	fake := &command.Fake{Results: results}
+
+	previousExecutor := command.SetExecutor(fake)
+	previousBackend := backend
 
-func Test_HelperProcess(t *testing.T){
-	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
-		return
+	backend = helm2{}
+
+	return fake, func() {
+		command.SetExecutor(previousExecutor)
+		backend = previousBackend
 	}
-	// check arguments perhaps?
-	//fmt.Printf(os.Stdout, "asfd")
-	os.Exit(0)
+}
+
+func fixtureResult(t *testing.T, name string) command.Result {
+	return command.Result{Stdout: string(readFixture(t, name))}
 }
 
 func Test_Exists(t *testing.T) {
-	/*execCommand = helperCommand
-	defer func() { execCommand = exec.Command }()
+	fake, restore := useFake(
+		command.Result{Stdout: "LAST DEPLOYED: Fri Oct 17 09:12:31 2026\nNAMESPACE: default\nSTATUS: DEPLOYED\n"},
+		command.Result{Stderr: string(readFixture(t, "helm2_status_not_found.txt")), ExitCode: 1},
+		command.Result{Stderr: string(readFixture(t, "helm2_tiller_missing.txt")), ExitCode: 1},
+	)
+	defer restore()
+
+	if exists, err := Exists("helmi3b2e7d2c9152", ""); !exists || err != nil {
+		t.Error(red("existing release not found"))
+	}
+	if exists, err := Exists("helmi3b2e7d2c9152", ""); exists || err != nil {
+		t.Error(red("missing release reported as existing"))
+	}
+	if _, err := Exists("helmi3b2e7d2c9152", ""); err == nil {
+		t.Error(red("helm error not returned"))
+	}
+	if fake.Commands[0] != "helm status helmi3b2e7d2c9152" {
+		t.Error(red("incorrect command: " + fake.Commands[0]))
+	}
+}
+
+func Test_Install(t *testing.T) {
+	fake, restore := useFake(command.Result{}, command.Result{}, command.Result{Stderr: "Error: chart not found", ExitCode: 1})
+	defer restore()
+
+	values := map[string]string{
+		"password": "a,b",
+		"persistence.size": "1Gi",
+	}
+
+	Install("helmi3b2e7d2c9152", "", "monostream/redis", "1.2.3", values, Metadata{}, false)
+	Install("helmi3b2e7d2c9152", "", "monostream/redis", "", values, Metadata{}, true)
+
+	if fake.Commands[0] != "helm install monostream/redis --name helmi3b2e7d2c9152 --version 1.2.3 --wait --set-string password=a\\,b --set-string persistence.size=1Gi" {
+		t.Error(red("incorrect synchronous install: " + fake.Commands[0]))
+	}
+	if fake.Commands[1] != "helm install monostream/redis --name helmi3b2e7d2c9152 --set-string password=a\\,b --set-string persistence.size=1Gi" {
+		t.Error(red("incorrect asynchronous install: " + fake.Commands[1]))
+	}
+	if err := Install("helmi3b2e7d2c9152", "", "monostream/redis", "", nil, Metadata{}, true); err == nil || err.Error() != "Error: chart not found" {
+		t.Error(red("install error not returned"))
+	}
+}
+
+func Test_GetSetArguments(t *testing.T) {
+	arguments := getSetArguments(map[string]string{
+		"password": `secret\,image.repository=evil`,
+		"port":     "3306",
+	})
+
+	expected := `--set-string password=secret\\\,image.repository=evil --set-string port=3306`
+
+	if strings.Join(arguments, " ") != expected {
+		t.Error(red("incorrect set arguments: " + strings.Join(arguments, " ")))
+	}
+}
+
+func Test_InstallNamespace(t *testing.T) {
+	fake, restore := useFake(command.Result{})
+	defer restore()
+
+	Install("helmi3b2e7d2c9152", "services", "monostream/redis", "", nil, Metadata{}, true)
+
+	if fake.Commands[0] != "helm install monostream/redis --name helmi3b2e7d2c9152 --namespace services" {
+		t.Error(red("incorrect namespaced install: " + fake.Commands[0]))
+	}
+}
+
+func Test_Upgrade(t *testing.T) {
+	fake, restore := useFake(command.Result{})
+	defer restore()
+
+	Upgrade("helmi3b2e7d2c9152", "", "monostream/redis", "1.2.3", map[string]string{"persistence.size": "2Gi"}, Metadata{}, true)
+
+	if fake.Commands[0] != "helm upgrade helmi3b2e7d2c9152 monostream/redis --version 1.2.3 --set-string persistence.size=2Gi" {
+		t.Error(red("incorrect upgrade: " + fake.Commands[0]))
+	}
+}
+
+func Test_Delete(t *testing.T) {
+	fake, restore := useFake(command.Result{}, command.Result{Stderr: "Error: could not find tiller", ExitCode: 1})
+	defer restore()
+
+	if err := Delete("helmi3b2e7d2c9152", ""); err != nil {
+		t.Error(red("delete failed"))
+	}
+	if fake.Commands[0] != "helm delete helmi3b2e7d2c9152 --purge" {
+		t.Error(red("incorrect delete: " + fake.Commands[0]))
+	}
+	if err := Delete("helmi3b2e7d2c9152", ""); err == nil {
+		t.Error(red("delete error not returned"))
+	}
+}
+
+func Test_GetValues(t *testing.T) {
+	fake, restore := useFake(fixtureResult(t, "helm2_values.yaml"))
+	defer restore()
+
+	values, err := GetValues("helmi3b2e7d2c9152", "")
+
+	if err != nil {
+		t.Fatal(red("failed to get values"))
+	}
+	if values["persistence.size"] != "1Gi" || values["resources.requests.memory"] != "256Mi" || values["redisPassword"] != "secret" {
+		t.Error(red("incorrect values returned"))
+	}
+	if fake.Commands[0] != "helm get values helmi3b2e7d2c9152 --all" {
+		t.Error(red("incorrect command: " + fake.Commands[0]))
+	}
+}
+
+func Test_GetStatus(t *testing.T) {
+	fake, restore := useFake(
+		fixtureResult(t, "helm2_status.json"),
+		fixtureResult(t, "helm2_manifest.yaml"),
+		fixtureResult(t, "kubectl_service.json"),
+		fixtureResult(t, "kubectl_deployment.json"),
+	)
+	defer restore()
+
+	status, err := GetStatus("helmi3b2e7d2c9152", "")
+
+	if err != nil {
+		t.Fatal(red("failed to get status"))
+	}
+	if !status.IsDeployed || status.Namespace != "default" {
+		t.Error(red("incorrect release status"))
+	}
+	if status.DesiredNodes != 3 || status.AvailableNodes != 2 || status.IsReady() {
+		t.Error(red("incorrect nodes returned"))
+	}
+	if status.NodePorts[6379] != 30001 {
+		t.Error(red("incorrect node port returned"))
+	}
+	if len(status.Resources) != 3 {
+		t.Error(red("incorrect resources returned"))
+	}
+	if fake.Commands[2] != "kubectl get service helmi3b2e7d2c9152-redis --output json --namespace default" {
+		t.Error(red("incorrect resource command: " + fake.Commands[2]))
+	}
+}
+
+func Test_GetStatusFailed(t *testing.T) {
+	_, restore := useFake(
+		fixtureResult(t, "helm2_status_failed.json"),
+		command.Result{},
+	)
+	defer restore()
+
+	status, err := GetStatus("helmi3b2e7d2c9152", "")
+
+	if err != nil || !status.IsFailed {
+		t.Error(red("failed release not recognized"))
+	}
+}
+
+func Test_GetStatusMissingResource(t *testing.T) {
+	_, restore := useFake(
+		fixtureResult(t, "helm2_status.json"),
+		fixtureResult(t, "helm2_manifest.yaml"),
+		fixtureResult(t, "kubectl_service.json"),
+		command.Result{Stderr: `Error from server (NotFound): deployments.apps "helmi3b2e7d2c9152-redis" not found`, ExitCode: 1},
+	)
+	defer restore()
+
+	status, err := GetStatus("helmi3b2e7d2c9152", "")
+
+	if err != nil || status.IsReady() {
+		t.Error(red("missing deployment reported as ready"))
+	}
+}
+
+func Test_GetStatus3(t *testing.T) {
+	fake, restore := useFake(
+		fixtureResult(t, "helm3_status_deployed.json"),
+		fixtureResult(t, "kubectl_service.json"),
+		fixtureResult(t, "kubectl_deployment.json"),
+	)
+	defer restore()
+
+	backend = helm3{Namespace: "services"}
+
+	status, err := GetStatus("helmi3b2e7d2c9152", "")
+
+	if err != nil || !status.IsDeployed || status.AvailableNodes != 2 {
+		t.Error(red("incorrect helm 3 status"))
+	}
+	if fake.Commands[0] != "helm status helmi3b2e7d2c9152 --output json --namespace services" {
+		t.Error(red("incorrect command: " + fake.Commands[0]))
+	}
+}
+
+func Test_GetNamespace(t *testing.T) {
+	_, restore := useFake()
+	defer restore()
+
+	if namespace := GetNamespace(""); namespace != "default" {
+		t.Error(red("incorrect helm 2 namespace: " + namespace))
+	}
+
+	backend = helm3{Namespace: "services"}
+
+	if namespace := GetNamespace(""); namespace != "services" {
+		t.Error(red("incorrect helm 3 namespace: " + namespace))
+	}
+	if namespace := GetNamespace("mariadb"); namespace != "mariadb" {
+		t.Error(red("incorrect given namespace: " + namespace))
+	}
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
 
-	out, err := Exists("test")
 	if err != nil {
-		t.Errorf("Expected nil error, got %#v", err)
+		t.Fatal(red("missing fixture " + name))
+	}
+
+	return data
+}
+
+func Test_ParseStatus3(t *testing.T) {
+	status, manifest, lastDeployed, err := parseStatus3(readFixture(t, "helm3_status_deployed.json"))
+
+	if err != nil {
+		t.Fatal(red("failed to parse helm 3 status"))
+	}
+	if status.Name != "helmi3b2e7d2c9152" || status.Namespace != "services" || status.Revision != 1 {
+		t.Error(red("incorrect release name, namespace or revision"))
 	}
-	if out != true {
-		t.Errorf("Expected %q, got %q", true, out)
-	}*/
-}
\ No newline at end of file
+	if !status.IsDeployed || status.IsFailed {
+		t.Error(red("deployed release not recognized"))
+	}
+	if len(manifest) == 0 {
+		t.Error(red("manifest missing"))
+	}
+	if lastDeployed.IsZero() {
+		t.Error(red("deployment time missing"))
+	}
+}
+
+func Test_ParseStatus3Failed(t *testing.T) {
+	status, _, _, _ := parseStatus3(readFixture(t, "helm3_status_failed.json"))
+
+	if status.IsDeployed || !status.IsFailed {
+		t.Error(red("failed release not recognized"))
+	}
+}
+
+func Test_ParseStatus3Pending(t *testing.T) {
+	status, _, _, _ := parseStatus3(readFixture(t, "helm3_status_pending.json"))
+
+	if status.IsDeployed || status.IsFailed {
+		t.Error(red("pending release not recognized"))
+	}
+}
+
+func Test_IsNotFound3(t *testing.T) {
+	if !isNotFound3(readFixture(t, "helm3_status_not_found.txt")) {
+		t.Error(red("missing release not recognized"))
+	}
+	if isNotFound3(readFixture(t, "helm3_status_deployed.json")) {
+		t.Error(red("existing release reported as missing"))
+	}
+}
+
+func Test_ParseValues3(t *testing.T) {
+	values, err := parseValues3(readFixture(t, "helm3_values.json"))
+
+	if err != nil {
+		t.Fatal(red("failed to parse helm 3 values"))
+	}
+	if values["persistence.size"] != "1Gi" {
+		t.Error(red("incorrect nested value returned"))
+	}
+	if values["persistence.enabled"] != "true" {
+		t.Error(red("incorrect boolean value returned"))
+	}
+	if values["replicas"] != "2" {
+		t.Error(red("incorrect number value returned"))
+	}
+}
+
+func Test_ParseManifest(t *testing.T) {
+	_, manifest, _, _ := parseStatus3(readFixture(t, "helm3_status_deployed.json"))
+
+	resources, err := parseManifest(manifest)
+
+	if err != nil || len(resources) != 3 {
+		t.Fatal(red("incorrect number of resources returned"))
+	}
+	if resources[1].Kind != "Service" || resources[1].Name != "helmi3b2e7d2c9152-redis" {
+		t.Error(red("incorrect service returned"))
+	}
+	if resources[2].Kind != "Deployment" || resources[2].Name != "helmi3b2e7d2c9152-redis" {
+		t.Error(red("nested metadata read as resource name"))
+	}
+
+	resources, err = parseManifest("---\n# Source: empty.yaml\n---\nkind: StatefulSet\nmetadata:\n    labels:\n        name: label\n    name: \"helmi-db\"\n    namespace: services\n")
+
+	if err != nil || len(resources) != 1 || resources[0].Name != "helmi-db" || resources[0].Namespace != "services" {
+		t.Error(red("indented metadata not read"))
+	}
+
+	if _, err := parseManifest("kind: Service\nmetadata: [name\n"); err == nil {
+		t.Error(red("invalid manifest not reported"))
+	}
+}
+
+func newStatus() Status {
+	return Status{
+		NodePorts:    map[int]int{},
+		ClusterPorts: map[int]int{},
+	}
+}
+
+func readResourceFixture(t *testing.T, kind string, name string) Resource {
+	status := newStatus()
+
+	if err := addResourceStatus(Resource{Kind: kind, Name: name}, readFixture(t, name), &status); err != nil {
+		t.Fatal(red("failed to read resource " + name))
+	}
+
+	return status.Resources[0]
+}
+
+func Test_AddResourceStatus(t *testing.T) {
+	status := newStatus()
+
+	addResourceStatus(Resource{Kind: "Deployment"}, readFixture(t, "kubectl_deployment.json"), &status)
+	addResourceStatus(Resource{Kind: "Service"}, readFixture(t, "kubectl_service.json"), &status)
+
+	if status.DesiredNodes != 3 || status.AvailableNodes != 2 {
+		t.Error(red("incorrect replicas returned"))
+	}
+	if status.NodePorts[6379] != 30001 || status.ClusterPorts[6379] != 6379 {
+		t.Error(red("incorrect ports returned"))
+	}
+	if len(status.Resources) != 2 || status.IsReady() {
+		t.Error(red("unavailable deployment reported as ready"))
+	}
+
+	addResourceStatus(Resource{Kind: "Ingress"}, readFixture(t, "kubectl_ingress.json"), &status)
+
+	if len(status.IngressHosts) != 1 || status.IngressHosts[0] != "rabbitmq.example.com" || !status.Resources[2].IsReady {
+		t.Error(red("incorrect ingress hosts returned"))
+	}
+}
+
+func Test_ResourceReadiness(t *testing.T) {
+	if r := readResourceFixture(t, "Deployment", "kubectl_deployment.json"); r.IsReady || r.Message != "2/3 replicas available" {
+		t.Error(red("incorrect deployment readiness"))
+	}
+	if r := readResourceFixture(t, "StatefulSet", "kubectl_statefulset.json"); !r.IsReady {
+		t.Error(red("incorrect statefulset readiness"))
+	}
+	if r := readResourceFixture(t, "DaemonSet", "kubectl_daemonset.json"); !r.IsReady {
+		t.Error(red("incorrect daemonset readiness"))
+	}
+	if r := readResourceFixture(t, "Service", "kubectl_service.json"); !r.IsReady {
+		t.Error(red("incorrect service readiness"))
+	}
+	if r := readResourceFixture(t, "Service", "kubectl_service_loadbalancer.json"); r.IsReady {
+		t.Error(red("load balancer without ingress reported as ready"))
+	}
+	if r := readResourceFixture(t, "PersistentVolumeClaim", "kubectl_pvc.json"); !r.IsReady {
+		t.Error(red("incorrect persistent volume claim readiness"))
+	}
+	if r := readResourceFixture(t, "Job", "kubectl_job_failed.json"); r.IsReady || !r.IsFailed {
+		t.Error(red("failed job not recognized"))
+	}
+}
+
+func Test_ParseStatus2(t *testing.T) {
+	status, lastDeployed, err := parseStatus2(readFixture(t, "helm2_status.json"))
+
+	if err != nil {
+		t.Fatal(red("failed to parse helm 2 status"))
+	}
+	if status.Name != "helmi3b2e7d2c9152" || status.Namespace != "default" || status.Revision != 2 {
+		t.Error(red("incorrect release name, namespace or revision"))
+	}
+	if !status.IsDeployed || status.IsFailed {
+		t.Error(red("deployed release not recognized"))
+	}
+	if lastDeployed.Unix() != 1760685151 {
+		t.Error(red("incorrect deployment time"))
+	}
+
+	status, _, _ = parseStatus2(readFixture(t, "helm2_status_failed.json"))
+
+	if status.IsDeployed || !status.IsFailed {
+		t.Error(red("failed release not recognized"))
+	}
+}
+
+func Test_GetArguments3(t *testing.T) {
+	arguments := helm3{Namespace: "services"}.getArguments([] string{"install", "release", "chart"}, "services", "1.2.3", map[string]string{"a": "b,c"}, false)
+
+	expected := "install release chart --namespace services --version 1.2.3 --wait --set-string a=b\\,c"
+
+	if strings.Join(arguments, " ") != expected {
+		t.Error(red("incorrect install arguments: " + strings.Join(arguments, " ")))
+	}
+}
+
+func Test_ParseReleaseSecrets3(t *testing.T) {
+	release, err := parseReleaseSecrets3(readFixture(t, "helm3_release_secrets.json"))
+
+	if err != nil || release == nil {
+		t.Fatal(red("failed to decode helm 3 release secrets"))
+	}
+	if release.Version != 2 {
+		t.Error(red("latest release revision not returned"))
+	}
+
+	status := release.getStatus()
+
+	if !status.IsDeployed || status.Namespace != "services" {
+		t.Error(red("incorrect release status"))
+	}
+	if resources, _ := parseManifest(release.Manifest); len(resources) != 3 {
+		t.Error(red("incorrect release manifest"))
+	}
+
+	values := readJsonProperties(mergeValues(release.Chart.Values, release.Config), "")
+
+	if values["persistence.size"] != "2Gi" || values["persistence.enabled"] != "true" || values["redisPassword"] != "secret" {
+		t.Error(red("incorrect release values"))
+	}
+
+	release, err = parseReleaseSecrets3(readFixture(t, "helm3_release_secrets_empty.json"))
+
+	if err != nil || release != nil {
+		t.Error(red("missing release returned"))
+	}
+}
+
+func Test_Install3PostRender(t *testing.T) {
+	fake, restore := useFake(command.Result{})
+	defer restore()
+
+	backend = helm3{Namespace: "services"}
+
+	metadata := Metadata{Labels: map[string]string{"helmi/platform": "cloudfoundry"}}
+
+	Install("helmi3b2e7d2c9152", "", "monostream/redis", "", nil, metadata, true)
+
+	if !strings.Contains(fake.Commands[0], " --post-renderer ") {
+		t.Error(red("post renderer not passed: " + fake.Commands[0]))
+	}
+	if len(fake.Envs[0]) != 1 || fake.Envs[0][0] != `HELMI_POST_RENDER={"labels":{"helmi/platform":"cloudfoundry"}}` {
+		t.Error(red("post renderer metadata not passed"))
+	}
+}
+
+func Test_AddMetadata(t *testing.T) {
+	manifests := "---\n# Source: redis/templates/svc.yaml\napiVersion: v1\nkind: Service\nmetadata:\n  name: redis\n  labels:\n    app: redis\nspec:\n  type: ClusterIP\n---\napiVersion: v1\nkind: Secret\nmetadata:\n  name: redis\n"
+
+	metadata := Metadata{
+		Labels:      map[string]string{"helmi/platform": "kubernetes"},
+		Annotations: map[string]string{"helmi/instance-name": "my redis"},
+	}
+
+	output, err := addMetadata([]byte(manifests), metadata)
+
+	if err != nil {
+		t.Fatal(red("failed to add metadata: " + err.Error()))
+	}
+
+	resources, err := parseManifest(string(output))
+
+	if err != nil {
+		t.Fatal(red("failed to parse post rendered manifests: " + err.Error()))
+	}
+
+	if len(resources) != 2 || resources[0].Kind != "Service" || resources[1].Name != "redis" {
+		t.Error(red("objects changed by post render"))
+	}
+	if !strings.Contains(string(output), "  labels:\n    app: redis\n    helmi/platform: kubernetes\n") {
+		t.Error(red("labels not merged: " + string(output)))
+	}
+	if strings.Count(string(output), "helmi/instance-name: my redis") != 2 {
+		t.Error(red("annotations not added: " + string(output)))
+	}
+}
@@ -0,0 +1,179 @@
+package helm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// postRenderEnv passes the metadata to helmi when helm 3 runs it as post renderer
+const postRenderEnv = "HELMI_POST_RENDER"
+
+// Metadata is added to every object of a release
+type Metadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func (m Metadata) isEmpty() bool {
+	return len(m.Labels) == 0 && len(m.Annotations) == 0
+}
+
+// IsPostRender returns true if helmi was started by helm as post renderer
+func IsPostRender() bool {
+	_, exists := os.LookupEnv(postRenderEnv)
+	return exists
+}
+
+// PostRender reads the rendered manifests and writes them with the metadata of the environment
+func PostRender(input io.Reader, output io.Writer) error {
+	var metadata Metadata
+
+	if err := json.Unmarshal([]byte(os.Getenv(postRenderEnv)), &metadata); err != nil {
+		return err
+	}
+
+	manifests, err := ioutil.ReadAll(input)
+
+	if err != nil {
+		return err
+	}
+
+	rendered, err := addMetadata(manifests, metadata)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = output.Write(rendered)
+
+	return err
+}
+
+// getPostRenderArguments runs helmi itself as post renderer, helm passes its environment on
+func getPostRenderArguments(metadata Metadata) ([]string, []string, error) {
+	if metadata.isEmpty() {
+		return nil, nil, nil
+	}
+
+	executable, err := os.Executable()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encoded, err := json.Marshal(metadata)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []string{"--post-renderer", executable}, []string{postRenderEnv + "=" + string(encoded)}, nil
+}
+
+// addMetadata merges labels and annotations into the metadata of every document of a multi document manifest
+func addMetadata(manifests []byte, metadata Metadata) ([]byte, error) {
+	var output bytes.Buffer
+
+	for _, document := range splitDocuments(manifests) {
+		var object yaml.MapSlice
+
+		if err := yaml.Unmarshal([]byte(document), &object); err != nil {
+			return nil, err
+		}
+
+		if len(object) == 0 {
+			continue
+		}
+
+		objectMetadata := getMapItem(object, "metadata")
+		objectMetadata = setMapItems(objectMetadata, "labels", metadata.Labels)
+		objectMetadata = setMapItems(objectMetadata, "annotations", metadata.Annotations)
+
+		object = setMapItem(object, "metadata", objectMetadata)
+
+		rendered, err := yaml.Marshal(object)
+
+		if err != nil {
+			return nil, err
+		}
+
+		output.WriteString("---\n")
+		output.Write(rendered)
+	}
+
+	return output.Bytes(), nil
+}
+
+func splitDocuments(manifests []byte) []string {
+	var documents []string
+	var current []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(manifests))
+	scanner.Buffer(make([]byte, 64*1024), len(manifests)+1)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "---") {
+			documents = append(documents, strings.Join(current, "\n"))
+			current = nil
+			continue
+		}
+
+		current = append(current, line)
+	}
+
+	return append(documents, strings.Join(current, "\n"))
+}
+
+func getMapItem(m yaml.MapSlice, key string) yaml.MapSlice {
+	for _, item := range m {
+		if item.Key == key {
+			value, _ := item.Value.(yaml.MapSlice)
+			return value
+		}
+	}
+
+	return nil
+}
+
+func setMapItem(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for index, item := range m {
+		if item.Key == key {
+			m[index].Value = value
+			return m
+		}
+	}
+
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+// setMapItems sets the values in a child map sorted by key, existing values are overridden
+func setMapItems(m yaml.MapSlice, key string, values map[string]string) yaml.MapSlice {
+	if len(values) == 0 {
+		return m
+	}
+
+	child := getMapItem(m, key)
+
+	var keys []string
+
+	for valueKey := range values {
+		keys = append(keys, valueKey)
+	}
+
+	sort.Strings(keys)
+
+	for _, valueKey := range keys {
+		child = setMapItem(child, valueKey, values[valueKey])
+	}
+
+	return setMapItem(m, key, child)
+}
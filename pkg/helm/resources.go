@@ -0,0 +1,236 @@
+package helm
+
+import (
+	"encoding/json"
+	"github.com/monostream/helmi/pkg/kubectl"
+	"gopkg.in/yaml.v2"
+	"strconv"
+	"strings"
+)
+
+// Resource is a kubernetes object rendered by a chart and its readiness
+type Resource struct {
+	Kind      string
+	Name      string
+	Namespace string
+
+	IsReady  bool
+	IsFailed bool
+	Message  string
+}
+
+// object contains the fields of all kinds needed to decide their readiness
+type object struct {
+	Metadata struct {
+		Generation int64 `json:"generation"`
+	} `json:"metadata"`
+
+	Spec struct {
+		Type        string `json:"type"`
+		Replicas    *int   `json:"replicas"`
+		Completions *int   `json:"completions"`
+
+		BackoffLimit *int `json:"backoffLimit"`
+
+		Ports []struct {
+			Port     int `json:"port"`
+			NodePort int `json:"nodePort"`
+		} `json:"ports"`
+
+		Rules []struct {
+			Host string `json:"host"`
+		} `json:"rules"`
+	} `json:"spec"`
+
+	Status struct {
+		ObservedGeneration int64 `json:"observedGeneration"`
+
+		Replicas          int `json:"replicas"`
+		ReadyReplicas     int `json:"readyReplicas"`
+		UpdatedReplicas   int `json:"updatedReplicas"`
+		AvailableReplicas int `json:"availableReplicas"`
+
+		CurrentRevision string `json:"currentRevision"`
+		UpdateRevision  string `json:"updateRevision"`
+
+		DesiredNumberScheduled int `json:"desiredNumberScheduled"`
+		NumberAvailable        int `json:"numberAvailable"`
+		UpdatedNumberScheduled int `json:"updatedNumberScheduled"`
+
+		Succeeded int `json:"succeeded"`
+		Failed    int `json:"failed"`
+
+		Phase string `json:"phase"`
+
+		LoadBalancer struct {
+			Ingress []interface{} `json:"ingress"`
+		} `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+// parseManifest lists the objects of a multi document release manifest
+func parseManifest(manifest string) ([]Resource, error) {
+	var resources []Resource
+
+	for _, document := range splitDocuments([]byte(manifest)) {
+		var object struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Name      string `yaml:"name"`
+				Namespace string `yaml:"namespace"`
+			} `yaml:"metadata"`
+		}
+
+		if err := yaml.Unmarshal([]byte(document), &object); err != nil {
+			return nil, err
+		}
+
+		// documents of templates which rendered nothing contain only comments
+		if len(object.Kind) == 0 {
+			continue
+		}
+
+		resources = append(resources, Resource{
+			Kind:      object.Kind,
+			Name:      object.Metadata.Name,
+			Namespace: object.Metadata.Namespace,
+		})
+	}
+
+	return resources, nil
+}
+
+// isTracked returns true for kinds whose readiness is checked
+func isTracked(kind string) bool {
+	switch strings.ToLower(kind) {
+	case "deployment", "statefulset", "daemonset", "service", "persistentvolumeclaim", "job", "ingress":
+		return true
+	}
+
+	return false
+}
+
+// readResourceStatus reads the objects of the release from the cluster and adds them to the status
+func readResourceStatus(namespace string, resources []Resource, status *Status) error {
+	for _, r := range resources {
+		if len(r.Namespace) == 0 {
+			r.Namespace = namespace
+		}
+
+		if !isTracked(r.Kind) {
+			r.IsReady = true
+			status.Resources = append(status.Resources, r)
+			continue
+		}
+
+		output, err := kubectl.GetResource(r.Namespace, strings.ToLower(r.Kind), r.Name)
+
+		if err != nil {
+			return err
+		}
+
+		if output == nil {
+			r.Message = "not found"
+			status.Resources = append(status.Resources, r)
+			continue
+		}
+
+		if err := addResourceStatus(r, output, status); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addResourceStatus applies the readiness rules of the kind to an object
+func addResourceStatus(r Resource, output []byte, status *Status) error {
+	var o object
+
+	if err := json.Unmarshal(output, &o); err != nil {
+		return err
+	}
+
+	replicas := 1
+
+	if o.Spec.Replicas != nil {
+		replicas = *o.Spec.Replicas
+	}
+
+	observed := o.Status.ObservedGeneration >= o.Metadata.Generation
+
+	switch strings.ToLower(r.Kind) {
+	case "deployment":
+		r.IsReady = observed && o.Status.UpdatedReplicas >= replicas && o.Status.AvailableReplicas >= replicas
+		r.Message = getReplicaMessage(o.Status.AvailableReplicas, replicas, "available")
+
+		status.DesiredNodes += replicas
+		status.AvailableNodes += o.Status.AvailableReplicas
+	case "statefulset":
+		updated := len(o.Status.UpdateRevision) == 0 || o.Status.CurrentRevision == o.Status.UpdateRevision
+
+		r.IsReady = observed && updated && o.Status.ReadyReplicas >= replicas
+		r.Message = getReplicaMessage(o.Status.ReadyReplicas, replicas, "ready")
+
+		status.DesiredNodes += replicas
+		status.AvailableNodes += o.Status.ReadyReplicas
+	case "daemonset":
+		desired := o.Status.DesiredNumberScheduled
+
+		r.IsReady = observed && o.Status.UpdatedNumberScheduled >= desired && o.Status.NumberAvailable >= desired
+		r.Message = getReplicaMessage(o.Status.NumberAvailable, desired, "available")
+
+		status.DesiredNodes += desired
+		status.AvailableNodes += o.Status.NumberAvailable
+	case "service":
+		for _, port := range o.Spec.Ports {
+			status.ClusterPorts[port.Port] = port.Port
+
+			if port.NodePort > 0 {
+				status.NodePorts[port.Port] = port.NodePort
+			}
+		}
+
+		r.IsReady = o.Spec.Type != "LoadBalancer" || len(o.Status.LoadBalancer.Ingress) > 0
+
+		if !r.IsReady {
+			r.Message = "waiting for load balancer"
+		}
+	case "ingress":
+		for _, rule := range o.Spec.Rules {
+			if len(rule.Host) > 0 {
+				status.IngressHosts = append(status.IngressHosts, rule.Host)
+			}
+		}
+
+		// many ingress controllers never report an address
+		r.IsReady = true
+	case "persistentvolumeclaim":
+		r.IsReady = o.Status.Phase == "Bound"
+		r.Message = strings.ToLower(o.Status.Phase)
+	case "job":
+		completions := 1
+
+		if o.Spec.Completions != nil {
+			completions = *o.Spec.Completions
+		}
+
+		backoffLimit := 6
+
+		if o.Spec.BackoffLimit != nil {
+			backoffLimit = *o.Spec.BackoffLimit
+		}
+
+		r.IsReady = o.Status.Succeeded >= completions
+		r.IsFailed = !r.IsReady && o.Status.Failed > backoffLimit
+		r.Message = strconv.Itoa(o.Status.Succeeded) + "/" + strconv.Itoa(completions) + " completions"
+	}
+
+	status.Resources = append(status.Resources, r)
+
+	return nil
+}
+
+func getReplicaMessage(current int, desired int, state string) string {
+	return strconv.Itoa(current) + "/" + strconv.Itoa(desired) + " replicas " + state
+}
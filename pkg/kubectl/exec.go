@@ -0,0 +1,83 @@
+package kubectl
+
+import (
+	"errors"
+	"github.com/monostream/helmi/pkg/command"
+	"strings"
+)
+
+// execClient runs the kubectl cli
+type execClient struct {
+}
+
+func (c execClient) Get(namespace string, kind string, name string) ([]byte, error) {
+	arguments := []string{"get", kind, name, "--output", "json"}
+
+	if len(namespace) > 0 {
+		arguments = append(arguments, "--namespace", namespace)
+	}
+
+	output, err := command.Run("kubectl", arguments...)
+
+	if err != nil {
+		if strings.Contains(strings.ToLower(string(output)), "not found") {
+			return nil, nil
+		}
+
+		return nil, errors.New(string(output[:]))
+	}
+
+	return output, nil
+}
+
+func (c execClient) List(namespace string, kind string, selector string) ([]byte, error) {
+	arguments := []string{"get", kind, "--output", "json"}
+
+	if len(selector) > 0 {
+		arguments = append(arguments, "--selector", selector)
+	}
+
+	if len(namespace) > 0 {
+		arguments = append(arguments, "--namespace", namespace)
+	}
+
+	output, err := command.Run("kubectl", arguments...)
+
+	if err != nil {
+		return nil, errors.New(string(output[:]))
+	}
+
+	return output, nil
+}
+
+func (c execClient) Apply(namespace string, manifest []byte) error {
+	arguments := []string{"apply", "--filename", "-"}
+
+	if len(namespace) > 0 {
+		arguments = append(arguments, "--namespace", namespace)
+	}
+
+	output, err := command.RunWithInput(manifest, "kubectl", arguments...)
+
+	if err != nil {
+		return errors.New(string(output[:]))
+	}
+
+	return nil
+}
+
+func (c execClient) Delete(namespace string, kind string, name string) error {
+	arguments := []string{"delete", kind, name, "--ignore-not-found"}
+
+	if len(namespace) > 0 {
+		arguments = append(arguments, "--namespace", namespace)
+	}
+
+	output, err := command.Run("kubectl", arguments...)
+
+	if err != nil {
+		return errors.New(string(output[:]))
+	}
+
+	return nil
+}
@@ -1,12 +1,14 @@
 package kubectl
 
 import (
+	"os"
 	"bytes"
 	"strings"
-	"os/exec"
 	"encoding/json"
+	"encoding/base64"
 	"github.com/jmoiron/jsonq"
 	"errors"
+	"time"
 )
 
 type Node struct {
@@ -17,14 +19,66 @@ type Node struct {
 	ExternalIP string
 }
 
+// Client talks to the kubernetes api, resources are passed as json
+type Client interface {
+	// Get returns nil without an error if the resource does not exist
+	Get(namespace string, kind string, name string) ([]byte, error)
+	List(namespace string, kind string, selector string) ([]byte, error)
+	Apply(namespace string, manifest []byte) error
+	// Delete ignores resources which do not exist
+	Delete(namespace string, kind string, name string) error
+}
+
+var client Client = execClient{}
+
+// Configure selects the client by the KUBERNETES_CLIENT environment variable,
+// by default the api is called directly when running inside a cluster
+func Configure() error {
+	clientType, _ := os.LookupEnv("KUBERNETES_CLIENT")
+
+	switch strings.ToLower(clientType) {
+	case "":
+		if native, err := newNativeClient(); err == nil {
+			client = native
+		} else {
+			client = execClient{}
+		}
+
+		return nil
+	case "native":
+		native, err := newNativeClient()
+
+		if err != nil {
+			return err
+		}
+
+		client = native
+		return nil
+	case "exec", "kubectl":
+		client = execClient{}
+		return nil
+	}
+
+	return errors.New("unknown kubernetes client " + clientType)
+}
+
+// IsNative returns true if the kubernetes api is called without kubectl
+func IsNative() bool {
+	_, native := client.(*nativeClient)
+	return native
+}
+
 func GetNodes() ([] Node, error) {
-	cmd := exec.Command("kubectl", "get", "nodes", "--output", "json")
-	output, err := cmd.CombinedOutput()
+	output, err := client.List("", "node", "")
 
 	if err != nil {
-		return nil, errors.New(string(output[:]))
+		return nil, err
 	}
 
+	return parseNodes(output)
+}
+
+func parseNodes(output []byte) ([] Node, error) {
 	data := map[string]interface{}{}
 
 	decoder := json.NewDecoder(bytes.NewReader(output))
@@ -45,8 +99,13 @@ func GetNodes() ([] Node, error) {
 
 		node := Node{}
 
+		// externalID is deprecated and missing on recent clusters
 		nodeId, err := itemQuery.String("spec", "externalID")
 
+		if err != nil {
+			nodeId, err = itemQuery.String("metadata", "name")
+		}
+
 		if err != nil {
 			return nil, err
 		}
@@ -92,3 +151,148 @@ func GetNodes() ([] Node, error) {
 
 	return nodes, nil
 }
+
+func Apply(namespace string, manifest []byte) error {
+	return client.Apply(namespace, manifest)
+}
+
+func Delete(namespace string, kind string, name string) error {
+	return client.Delete(namespace, kind, name)
+}
+
+// List returns the resources matching a label selector as json list
+func List(namespace string, kind string, selector string) ([]byte, error) {
+	return client.List(namespace, kind, selector)
+}
+
+func GetSecret(namespace string, name string) (map[string]string, error) {
+	data, err := getResourceData(namespace, "secret", name)
+
+	if data == nil || err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+
+	for key, value := range data {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values[key] = string(decoded)
+	}
+
+	return values, nil
+}
+
+func GetConfigMap(namespace string, name string) (map[string]string, error) {
+	return getResourceData(namespace, "configmap", name)
+}
+
+// GetResource returns a resource as json, nil if it does not exist
+func GetResource(namespace string, kind string, name string) ([]byte, error) {
+	return client.Get(namespace, kind, name)
+}
+
+// getResourceData returns the data of a secret or config map, nil if it does not exist
+func getResourceData(namespace string, kind string, name string) (map[string]string, error) {
+	output, err := GetResource(namespace, kind, name)
+
+	if output == nil || err != nil {
+		return nil, err
+	}
+
+	var resource struct {
+		Data map[string]string `json:"data"`
+	}
+
+	err = json.Unmarshal(output, &resource)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resource.Data == nil {
+		resource.Data = map[string]string{}
+	}
+
+	return resource.Data, nil
+}
+
+// WaitForDeletion polls a resource until it is gone, a deleted object may still exist while it terminates
+func WaitForDeletion(namespace string, kind string, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		output, err := client.Get(namespace, kind, name)
+
+		if err != nil {
+			return err
+		}
+
+		if output == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New(kind + " " + name + " is still terminating")
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// WaitForJob polls the job until it succeeded, failed or the timeout is reached
+func WaitForJob(namespace string, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		output, err := client.Get(namespace, "job", name)
+
+		if err != nil {
+			return err
+		}
+
+		if output == nil {
+			return errors.New("job " + name + " not found")
+		}
+
+		var job struct {
+			Spec struct {
+				BackoffLimit *int `json:"backoffLimit"`
+			} `json:"spec"`
+			Status struct {
+				Succeeded int `json:"succeeded"`
+				Failed    int `json:"failed"`
+			} `json:"status"`
+		}
+
+		err = json.Unmarshal(output, &job)
+
+		if err != nil {
+			return err
+		}
+
+		if job.Status.Succeeded > 0 {
+			return nil
+		}
+
+		backoffLimit := 6
+
+		if job.Spec.BackoffLimit != nil {
+			backoffLimit = *job.Spec.BackoffLimit
+		}
+
+		if job.Status.Failed > backoffLimit {
+			return errors.New("job " + name + " failed")
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("job " + name + " timed out")
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+}
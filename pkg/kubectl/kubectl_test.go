@@ -0,0 +1,217 @@
+package kubectl
+
+import (
+	"github.com/monostream/helmi/pkg/command"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func red(msg string) string {
+	return "\033[31m" + msg + "\033[39m\n\n"
+}
+
+func newTestClient(handler http.HandlerFunc) (*nativeClient, *httptest.Server) {
+	server := httptest.NewServer(handler)
+
+	return &nativeClient{
+		host:      server.URL,
+		namespace: "helmi",
+		client:    server.Client(),
+	}, server
+}
+
+func Test_GetPath(t *testing.T) {
+	c := &nativeClient{namespace: "helmi"}
+
+	if path, _ := c.getPath("", "Secret", "test"); path != "/api/v1/namespaces/helmi/secrets/test" {
+		t.Error(red("incorrect secret path: " + path))
+	}
+	if path, _ := c.getPath("services", "job", ""); path != "/apis/batch/v1/namespaces/services/jobs" {
+		t.Error(red("incorrect job list path: " + path))
+	}
+	if path, _ := c.getPath("services", "node", "test"); path != "/api/v1/nodes/test" {
+		t.Error(red("incorrect node path: " + path))
+	}
+	if _, err := c.getPath("", "unknown", "test"); err == nil {
+		t.Error(red("unknown kind accepted"))
+	}
+}
+
+func Test_NativeGet(t *testing.T) {
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/namespaces/helmi/configmaps/test" {
+			w.Write([]byte(`{"data":{"key":"value"}}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"kind":"Status","message":"configmaps \"other\" not found"}`))
+	})
+
+	defer server.Close()
+
+	client = c
+	defer func() { client = execClient{} }()
+
+	data, err := GetConfigMap("", "test")
+
+	if err != nil || data["key"] != "value" {
+		t.Error(red("config map not returned"))
+	}
+
+	data, err = GetConfigMap("", "other")
+
+	if err != nil || data != nil {
+		t.Error(red("missing config map not ignored"))
+	}
+}
+
+func Test_NativeApply(t *testing.T) {
+	var method, path, contentType, body string
+
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := ioutil.ReadAll(r.Body)
+
+		method = r.Method
+		path = r.URL.RequestURI()
+		contentType = r.Header.Get("Content-Type")
+		body = string(content)
+
+		w.Write([]byte(`{}`))
+	})
+
+	defer server.Close()
+
+	manifest := `{"apiVersion":"batch/v1","kind":"Job","metadata":{"name":"bind"}}`
+
+	if err := c.Apply("services", []byte(manifest)); err != nil {
+		t.Fatal(red("failed to apply manifest"))
+	}
+	if method != http.MethodPatch || path != "/apis/batch/v1/namespaces/services/jobs/bind?fieldManager=helmi&force=true" {
+		t.Error(red("incorrect apply request: " + method + " " + path))
+	}
+	if contentType != "application/apply-patch+yaml" || body != manifest {
+		t.Error(red("incorrect apply content"))
+	}
+}
+
+func Test_NativeDelete(t *testing.T) {
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/apis/batch/v1/namespaces/helmi/jobs/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"kind":"Status","message":"jobs is forbidden"}`))
+	})
+
+	defer server.Close()
+
+	if err := c.Delete("", "job", "missing"); err != nil {
+		t.Error(red("missing resource not ignored"))
+	}
+	if err := c.Delete("", "job", "forbidden"); err == nil || err.Error() != "jobs is forbidden" {
+		t.Error(red("api error not returned"))
+	}
+}
+
+func Test_NativeList(t *testing.T) {
+	var query string
+
+	c, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("labelSelector")
+		w.Write([]byte(`{"items":[]}`))
+	})
+
+	defer server.Close()
+
+	if _, err := c.List("", "secret", "owner=helm,name=test"); err != nil {
+		t.Fatal(red("failed to list resources"))
+	}
+	if query != "owner=helm,name=test" {
+		t.Error(red("incorrect label selector: " + query))
+	}
+}
+
+func Test_ParseNodes(t *testing.T) {
+	nodes, err := parseNodes([]byte(`{"items":[{"metadata":{"name":"node-1"},"spec":{},"status":{"addresses":[{"type":"InternalIP","address":"10.0.0.1"},{"type":"Hostname","address":"node-1"}]}}]}`))
+
+	if err != nil || len(nodes) != 1 {
+		t.Fatal(red("failed to parse nodes"))
+	}
+	if nodes[0].Name != "node-1" || nodes[0].InternalIP != "10.0.0.1" || nodes[0].Hostname != "node-1" {
+		t.Error(red("incorrect node returned"))
+	}
+}
+
+func Test_ExecGet(t *testing.T) {
+	fake := &command.Fake{Results: []command.Result{
+		{Stdout: `{"data":{"key":"dmFsdWU="}}`},
+		{Stderr: `Error from server (NotFound): secrets "other" not found`, ExitCode: 1},
+		{Stderr: `Error from server (Forbidden): secrets is forbidden`, ExitCode: 1},
+	}}
+
+	defer command.SetExecutor(command.SetExecutor(fake))
+
+	data, err := GetSecret("helmi", "test")
+
+	if err != nil || data["key"] != "value" {
+		t.Error(red("secret not decoded"))
+	}
+	if fake.Commands[0] != "kubectl get secret test --output json --namespace helmi" {
+		t.Error(red("incorrect command: " + fake.Commands[0]))
+	}
+	if data, err := GetSecret("helmi", "other"); data != nil || err != nil {
+		t.Error(red("missing secret not ignored"))
+	}
+	if _, err := GetSecret("helmi", "forbidden"); err == nil {
+		t.Error(red("kubectl error not returned"))
+	}
+}
+
+func Test_ExecApply(t *testing.T) {
+	fake := &command.Fake{Results: []command.Result{{}, {}}}
+
+	defer command.SetExecutor(command.SetExecutor(fake))
+
+	manifest := []byte(`{"kind":"Job"}`)
+
+	Apply("services", manifest)
+	Delete("services", "job", "bind")
+
+	if fake.Commands[0] != "kubectl apply --filename - --namespace services" || string(fake.Inputs[0]) != string(manifest) {
+		t.Error(red("manifest not passed to kubectl apply"))
+	}
+	if fake.Commands[1] != "kubectl delete job bind --ignore-not-found --namespace services" {
+		t.Error(red("incorrect command: " + fake.Commands[1]))
+	}
+}
+
+func Test_WaitForDeletion(t *testing.T) {
+	fake := &command.Fake{Results: []command.Result{
+		{Stdout: `{"metadata":{"deletionTimestamp":"2020-06-01T12:00:00Z"}}`},
+		{Stderr: `Error from server (NotFound): jobs.batch "bind" not found`, ExitCode: 1},
+	}}
+
+	defer command.SetExecutor(command.SetExecutor(fake))
+
+	if err := WaitForDeletion("services", "job", "bind", time.Minute); err != nil || len(fake.Commands) != 2 {
+		t.Error(red("terminating job not awaited"))
+	}
+}
+
+func Test_WaitForJob(t *testing.T) {
+	fake := &command.Fake{Results: []command.Result{
+		{Stdout: `{"spec":{"backoffLimit":1},"status":{"failed":2}}`},
+	}}
+
+	defer command.SetExecutor(command.SetExecutor(fake))
+
+	if err := WaitForJob("services", "bind", time.Minute); err == nil || err.Error() != "job bind failed" {
+		t.Error(red("failed job not recognized"))
+	}
+}
@@ -0,0 +1,264 @@
+package kubectl
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// mounted into every pod with a service account
+const serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// nativeClient calls the kubernetes api without kubectl
+type nativeClient struct {
+	host      string
+	tokenFile string
+	namespace string
+
+	client *http.Client
+}
+
+type resourceType struct {
+	group      string
+	plural     string
+	namespaced bool
+}
+
+// kinds used by helmi and the charts it checks
+var resourceTypes = map[string]resourceType{
+	"node":                  {"/api/v1", "nodes", false},
+	"namespace":             {"/api/v1", "namespaces", false},
+	"pod":                   {"/api/v1", "pods", true},
+	"secret":                {"/api/v1", "secrets", true},
+	"configmap":             {"/api/v1", "configmaps", true},
+	"service":               {"/api/v1", "services", true},
+	"persistentvolumeclaim": {"/api/v1", "persistentvolumeclaims", true},
+	"resourcequota":         {"/api/v1", "resourcequotas", true},
+	"limitrange":            {"/api/v1", "limitranges", true},
+	"deployment":            {"/apis/apps/v1", "deployments", true},
+	"statefulset":           {"/apis/apps/v1", "statefulsets", true},
+	"daemonset":             {"/apis/apps/v1", "daemonsets", true},
+	"job":                   {"/apis/batch/v1", "jobs", true},
+	"networkpolicy":         {"/apis/networking.k8s.io/v1", "networkpolicies", true},
+	"ingress":               {"/apis/networking.k8s.io/v1", "ingresses", true},
+	"helmiservice":          {"/apis/helmi.monostream.com/v1", "helmiservices", true},
+}
+
+// newNativeClient uses the KUBERNETES_API url (e.g. of `kubectl proxy`) or the service account of the pod
+func newNativeClient() (*nativeClient, error) {
+	if api, exists := os.LookupEnv("KUBERNETES_API"); exists {
+		return &nativeClient{
+			host:      strings.TrimSuffix(api, "/"),
+			namespace: "default",
+			client:    &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	}
+
+	host, hostExists := os.LookupEnv("KUBERNETES_SERVICE_HOST")
+	port, portExists := os.LookupEnv("KUBERNETES_SERVICE_PORT")
+
+	if !hostExists || !portExists {
+		return nil, errors.New("not running inside a kubernetes cluster")
+	}
+
+	certificate, err := ioutil.ReadFile(serviceAccountPath + "/ca.crt")
+
+	if err != nil {
+		return nil, err
+	}
+
+	certificates := x509.NewCertPool()
+
+	if !certificates.AppendCertsFromPEM(certificate) {
+		return nil, errors.New("invalid service account certificate")
+	}
+
+	namespace, err := ioutil.ReadFile(serviceAccountPath + "/namespace")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &nativeClient{
+		host:      "https://" + net.JoinHostPort(host, port),
+		tokenFile: serviceAccountPath + "/token",
+		namespace: strings.TrimSpace(string(namespace)),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: certificates},
+			},
+		},
+	}, nil
+}
+
+func (c *nativeClient) Get(namespace string, kind string, name string) ([]byte, error) {
+	path, err := c.getPath(namespace, kind, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	output, code, err := c.request(http.MethodGet, path, "", nil)
+
+	if code == http.StatusNotFound {
+		return nil, nil
+	}
+
+	return output, err
+}
+
+func (c *nativeClient) List(namespace string, kind string, selector string) ([]byte, error) {
+	path, err := c.getPath(namespace, kind, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(selector) > 0 {
+		path += "?labelSelector=" + url.QueryEscape(selector)
+	}
+
+	output, _, err := c.request(http.MethodGet, path, "", nil)
+
+	return output, err
+}
+
+// Apply uses server side apply, the manifest must be a single json object
+func (c *nativeClient) Apply(namespace string, manifest []byte) error {
+	var resource struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+
+	if err := json.Unmarshal(manifest, &resource); err != nil {
+		return err
+	}
+
+	if len(resource.Metadata.Namespace) > 0 {
+		namespace = resource.Metadata.Namespace
+	}
+
+	path, err := c.getPath(namespace, resource.Kind, resource.Metadata.Name)
+
+	if err != nil {
+		return err
+	}
+
+	_, _, err = c.request(http.MethodPatch, path+"?fieldManager=helmi&force=true", "application/apply-patch+yaml", manifest)
+
+	return err
+}
+
+func (c *nativeClient) Delete(namespace string, kind string, name string) error {
+	path, err := c.getPath(namespace, kind, name)
+
+	if err != nil {
+		return err
+	}
+
+	// like kubectl the dependents (e.g. pods of a job) are deleted as well
+	_, code, err := c.request(http.MethodDelete, path+"?propagationPolicy=Background", "", nil)
+
+	if code == http.StatusNotFound {
+		return nil
+	}
+
+	return err
+}
+
+func (c *nativeClient) getPath(namespace string, kind string, name string) (string, error) {
+	resource, exists := resourceTypes[strings.ToLower(kind)]
+
+	if !exists {
+		return "", errors.New("unsupported kind " + kind)
+	}
+
+	path := resource.group
+
+	if resource.namespaced {
+		if len(namespace) == 0 {
+			namespace = c.namespace
+		}
+
+		path += "/namespaces/" + namespace
+	}
+
+	path += "/" + resource.plural
+
+	if len(name) > 0 {
+		path += "/" + name
+	}
+
+	return path, nil
+}
+
+func (c *nativeClient) request(method string, path string, contentType string, body []byte) ([]byte, int, error) {
+	request, err := http.NewRequest(method, c.host+path, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	request.Header.Set("Accept", "application/json")
+
+	if len(contentType) > 0 {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	// service account tokens are rotated, so they are read for every request
+	if len(c.tokenFile) > 0 {
+		token, err := ioutil.ReadFile(c.tokenFile)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	response, err := c.client.Do(request)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer response.Body.Close()
+
+	output, err := ioutil.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, response.StatusCode, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, response.StatusCode, getStatusError(response.Status, output)
+	}
+
+	return output, response.StatusCode, nil
+}
+
+// getStatusError returns the message of a kubernetes status object
+func getStatusError(status string, output []byte) error {
+	var apiStatus struct {
+		Message string `json:"message"`
+	}
+
+	if json.Unmarshal(output, &apiStatus) == nil && len(apiStatus.Message) > 0 {
+		return errors.New(apiStatus.Message)
+	}
+
+	return errors.New(status)
+}
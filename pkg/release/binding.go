@@ -0,0 +1,772 @@
+package release
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/monostream/helmi/pkg/catalog"
+	"github.com/monostream/helmi/pkg/generator"
+	"github.com/monostream/helmi/pkg/kubectl"
+	"github.com/monostream/helmi/pkg/store"
+	"github.com/monostream/helmi/pkg/template"
+	"go.uber.org/zap"
+	"os"
+	"strconv"
+	"time"
+)
+
+const bindingValueId = "id"
+const bindingValueUsername = "username"
+const bindingValuePassword = "password"
+
+const defaultBindingTimeout = "5m"
+
+// release name, hash and the longest job suffix "-unbind" fit into a dns label
+const maxBindingReleaseLength = 45
+
+var ErrBindingNotFound = errors.New("service binding not found")
+var ErrOperationNotFound = errors.New("operation not found")
+
+// Bind creates the binding and returns its credentials
+func Bind(catalog *catalog.Catalog, stateStore store.Store, serviceId string, planId string, id string, bindingId string, parameters map[string]interface{}) (map[string]interface{}, error) {
+	done, err := reserve(getBindingKey(id, bindingId))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+
+	binding, err := getOrNewBinding(stateStore, serviceId, planId, id, bindingId, parameters)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if operation := binding.LastOperation(); operation != nil {
+		if operation.State == store.StateInProgress {
+			return nil, ErrOperationInProgress
+		}
+
+		// a repeated bind returns the already created credentials
+		if operation.State == store.StateSucceeded && binding.Credentials != nil {
+			return binding.Credentials, nil
+		}
+	}
+
+	operation := binding.StartOperation(store.OperationBind)
+
+	// concurrent requests see the operation in progress while the bind job runs
+	if err := saveBinding(stateStore, binding); err != nil {
+		return nil, err
+	}
+
+	err = createBinding(catalog, stateStore, binding, operation, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return binding.Credentials, nil
+}
+
+// CompareBinding checks a bind request against an already existing binding with the same id,
+// bindings which failed to be created can be retried with any request
+func CompareBinding(stateStore store.Store, serviceId string, planId string, id string, bindingId string, parameters map[string]interface{}) (Comparison, error) {
+	binding, err := stateStore.GetBinding(id, bindingId)
+
+	if err != nil {
+		getLogger().Error("failed to read binding from store",
+			zap.String("id", id),
+			zap.String("name", getReleaseName(stateStore, id)),
+			zap.String("bindingId", bindingId),
+			zap.Error(err))
+
+		return NotFound, err
+	}
+
+	if binding == nil || isFailedBinding(binding) {
+		return NotFound, nil
+	}
+
+	if !isIdentical(binding.ServiceId, binding.PlanId, binding.Parameters, serviceId, planId, parameters) {
+		return Conflicting, nil
+	}
+
+	if operation := binding.LastOperation(); operation != nil && operation.Type == store.OperationBind && operation.State == store.StateInProgress {
+		return InProgress, nil
+	}
+
+	return Identical, nil
+}
+
+// BindAsync starts creating the binding in the background, the returned
+// binding is either completed or has an operation in progress
+func BindAsync(catalog *catalog.Catalog, stateStore store.Store, serviceId string, planId string, id string, bindingId string, parameters map[string]interface{}) (*store.Binding, error) {
+	done, err := reserve(getBindingKey(id, bindingId))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+
+	binding, err := getOrNewBinding(stateStore, serviceId, planId, id, bindingId, parameters)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if operation := binding.LastOperation(); operation != nil {
+		if operation.State == store.StateInProgress && operation.Type != store.OperationBind {
+			return nil, ErrOperationInProgress
+		}
+
+		if operation.State == store.StateInProgress || (operation.State == store.StateSucceeded && binding.Credentials != nil) {
+			return binding, nil
+		}
+	}
+
+	operation := binding.StartOperation(store.OperationBind)
+
+	if err := saveBinding(stateStore, binding); err != nil {
+		return nil, err
+	}
+
+	go func(operationId string) {
+		binding, err := stateStore.GetBinding(id, bindingId)
+
+		if err != nil || binding == nil || binding.GetOperation(operationId) == nil {
+			return
+		}
+
+		createBinding(catalog, stateStore, binding, binding.GetOperation(operationId), true)
+	}(operation.Id)
+
+	return binding, nil
+}
+
+// GetBinding returns a stored binding and its credentials
+func GetBinding(catalog *catalog.Catalog, stateStore store.Store, id string, bindingId string) (*store.Binding, map[string]interface{}, error) {
+	name := getReleaseName(stateStore, id)
+	logger := getLogger()
+
+	binding, err := stateStore.GetBinding(id, bindingId)
+
+	if err != nil {
+		logger.Error("failed to read binding from store",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.String("bindingId", bindingId),
+			zap.Error(err))
+
+		return nil, nil, err
+	}
+
+	if binding == nil {
+		return nil, nil, ErrBindingNotFound
+	}
+
+	// bindings being created do not exist yet
+	if operation := binding.LastOperation(); operation != nil && operation.Type == store.OperationBind && operation.State != store.StateSucceeded {
+		return nil, nil, ErrBindingNotFound
+	}
+
+	if binding.Credentials != nil {
+		return binding, binding.Credentials, nil
+	}
+
+	service, _ := catalog.GetService(binding.ServiceId)
+	plan, _ := catalog.GetServicePlan(binding.ServiceId, binding.PlanId)
+
+	status, nodes, values, context, err := getLookupSources(stateStore, id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credentials, err := getUserCredentials(service, plan, nodes, status, values, binding.Values, context)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return binding, credentials, nil
+}
+
+// GetBindingOperation returns the operation with the given id or the last one if empty
+func GetBindingOperation(stateStore store.Store, id string, bindingId string, operationId string) (*store.Operation, error) {
+	binding, err := stateStore.GetBinding(id, bindingId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if binding == nil {
+		return nil, ErrBindingNotFound
+	}
+
+	if len(operationId) == 0 {
+		if operation := binding.LastOperation(); operation != nil {
+			return operation, nil
+		}
+
+		return nil, ErrOperationNotFound
+	}
+
+	if operation := binding.GetOperation(operationId); operation != nil {
+		return operation, nil
+	}
+
+	return nil, ErrOperationNotFound
+}
+
+func Unbind(catalog *catalog.Catalog, stateStore store.Store, serviceId string, planId string, id string, bindingId string) error {
+	done, err := reserve(getBindingKey(id, bindingId))
+
+	if err != nil {
+		return err
+	}
+
+	defer done()
+
+	binding, err := getExistingBinding(stateStore, id, bindingId)
+
+	if err != nil {
+		return err
+	}
+
+	if operation := binding.LastOperation(); operation != nil && operation.State == store.StateInProgress {
+		return ErrOperationInProgress
+	}
+
+	operation := binding.StartOperation(store.OperationUnbind)
+
+	return deleteBinding(catalog, stateStore, binding, operation, serviceId, planId)
+}
+
+// UnbindAsync starts deleting the binding in the background and returns the operation
+func UnbindAsync(catalog *catalog.Catalog, stateStore store.Store, serviceId string, planId string, id string, bindingId string) (*store.Operation, error) {
+	done, err := reserve(getBindingKey(id, bindingId))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+
+	binding, err := getExistingBinding(stateStore, id, bindingId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if operation := binding.LastOperation(); operation != nil && operation.State == store.StateInProgress {
+		if operation.Type == store.OperationUnbind {
+			return operation, nil
+		}
+
+		return nil, ErrOperationInProgress
+	}
+
+	operation := binding.StartOperation(store.OperationUnbind)
+
+	if err := saveBinding(stateStore, binding); err != nil {
+		return nil, err
+	}
+
+	go func(operationId string) {
+		binding, err := stateStore.GetBinding(id, bindingId)
+
+		if err != nil || binding == nil || binding.GetOperation(operationId) == nil {
+			return
+		}
+
+		deleteBinding(catalog, stateStore, binding, binding.GetOperation(operationId), serviceId, planId)
+	}(operation.Id)
+
+	return operation, nil
+}
+
+func getOrNewBinding(stateStore store.Store, serviceId string, planId string, id string, bindingId string, parameters map[string]interface{}) (*store.Binding, error) {
+	name := getReleaseName(stateStore, id)
+	logger := getLogger()
+
+	serviceId, planId, err := getInstanceIds(stateStore, id, serviceId, planId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	binding, err := stateStore.GetBinding(id, bindingId)
+
+	if err != nil {
+		logger.Error("failed to read binding from store",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.String("bindingId", bindingId),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	if binding == nil {
+		binding = &store.Binding{
+			Id:         bindingId,
+			InstanceId: id,
+		}
+	}
+
+	// a failed binding is retried with the new request
+	if binding.LastOperation() == nil || isFailedBinding(binding) {
+		binding.ServiceId = serviceId
+		binding.PlanId = planId
+		binding.Parameters = parameters
+	}
+
+	return binding, nil
+}
+
+func isFailedBinding(binding *store.Binding) bool {
+	operation := binding.LastOperation()
+
+	return operation != nil && operation.Type == store.OperationBind && operation.State == store.StateFailed
+}
+
+func getExistingBinding(stateStore store.Store, id string, bindingId string) (*store.Binding, error) {
+	binding, err := stateStore.GetBinding(id, bindingId)
+
+	if err != nil {
+		getLogger().Error("failed to read binding from store",
+			zap.String("id", id),
+			zap.String("name", getReleaseName(stateStore, id)),
+			zap.String("bindingId", bindingId),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	if binding == nil {
+		return nil, ErrBindingNotFound
+	}
+
+	return binding, nil
+}
+
+// createBinding runs the bind action, resolves the credentials and finishes the operation
+func createBinding(catalog *catalog.Catalog, stateStore store.Store, binding *store.Binding, operation *store.Operation, waitForRelease bool) error {
+	id := binding.InstanceId
+	name := getReleaseName(stateStore, id)
+	logger := getLogger()
+
+	service, _ := catalog.GetService(binding.ServiceId)
+	plan, _ := catalog.GetServicePlan(binding.ServiceId, binding.PlanId)
+
+	fail := func(err error) error {
+		logger.Error("failed to create binding",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.String("bindingId", binding.Id),
+			zap.Error(err))
+
+		operation.Finish(err)
+		saveBinding(stateStore, binding)
+
+		return err
+	}
+
+	// node ports and load balancers are assigned once the release is available
+	if waitForRelease {
+		if err := waitForAvailability(stateStore, id); err != nil {
+			return fail(err)
+		}
+	}
+
+	status, nodes, values, context, err := getLookupSources(stateStore, id)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	if action := getBindingActions(service, plan).Bind; action != nil && binding.Values == nil {
+		bindingValues, err := newBindingValues(binding.Id)
+
+		if err != nil {
+			return fail(err)
+		}
+
+		err = runBindingAction(service, plan, "bind", action, getBindingName(name, binding.Id)+"-bind", name, status.Namespace, getReleaseLookup(service, plan, nodes, status, values, bindingValues, context))
+
+		if err != nil {
+			return fail(err)
+		}
+
+		binding.Values = bindingValues
+	}
+
+	credentials, err := getUserCredentials(service, plan, nodes, status, values, binding.Values, context)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	binding.Credentials = credentials
+
+	operation.Finish(nil)
+
+	if err := saveBinding(stateStore, binding); err != nil {
+		return err
+	}
+
+	logger.Info("new binding created",
+		zap.String("id", id),
+		zap.String("name", name),
+		zap.String("bindingId", binding.Id))
+
+	return nil
+}
+
+// deleteBinding runs the unbind action and removes the binding from the store
+func deleteBinding(catalog *catalog.Catalog, stateStore store.Store, binding *store.Binding, operation *store.Operation, serviceId string, planId string) error {
+	id := binding.InstanceId
+	name := getReleaseName(stateStore, id)
+	logger := getLogger()
+
+	if len(serviceId) == 0 {
+		serviceId = binding.ServiceId
+	}
+
+	if len(planId) == 0 {
+		planId = binding.PlanId
+	}
+
+	service, _ := catalog.GetService(serviceId)
+	plan, _ := catalog.GetServicePlan(serviceId, planId)
+
+	if action := getBindingActions(service, plan).Unbind; action != nil && binding.Values != nil {
+		status, nodes, values, context, err := getLookupSources(stateStore, id)
+
+		if err == nil {
+			err = runBindingAction(service, plan, "unbind", action, getBindingName(name, binding.Id)+"-unbind", name, status.Namespace, getReleaseLookup(service, plan, nodes, status, values, binding.Values, context))
+		}
+
+		if err != nil {
+			logger.Error("failed to run unbind action",
+				zap.String("id", id),
+				zap.String("name", name),
+				zap.String("bindingId", binding.Id),
+				zap.Error(err))
+
+			operation.Finish(err)
+			saveBinding(stateStore, binding)
+
+			return err
+		}
+	}
+
+	err := stateStore.DeleteBinding(id, binding.Id)
+
+	if err != nil {
+		logger.Error("failed to delete binding from store",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.String("bindingId", binding.Id),
+			zap.Error(err))
+
+		return err
+	}
+
+	logger.Info("binding deleted",
+		zap.String("id", id),
+		zap.String("name", name),
+		zap.String("bindingId", binding.Id))
+
+	return nil
+}
+
+func saveBinding(stateStore store.Store, binding *store.Binding) error {
+	err := stateStore.SaveBinding(binding)
+
+	if err != nil {
+		getLogger().Error("failed to save binding to store",
+			zap.String("id", binding.InstanceId),
+			zap.String("bindingId", binding.Id),
+			zap.Error(err))
+	}
+
+	return err
+}
+
+// waitForAvailability polls the release status until it is available, failed or timed out
+func waitForAvailability(stateStore store.Store, id string) error {
+	timeout, exists := os.LookupEnv("TIMEOUT")
+
+	if !exists {
+		timeout = "30m"
+	}
+
+	duration, _ := time.ParseDuration(timeout)
+	deadline := time.Now().Add(duration)
+
+	for {
+		status, err := GetStatus(stateStore, id)
+
+		if err != nil {
+			return err
+		}
+
+		if status.IsFailed {
+			return errors.New("release failed")
+		}
+
+		if status.IsAvailable {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("release not available within " + timeout)
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func getBindingActions(service catalog.CatalogService, plan catalog.CatalogPlan) catalog.CatalogBinding {
+	if plan.Binding != nil {
+		return *plan.Binding
+	}
+
+	if service.Binding != nil {
+		return *service.Binding
+	}
+
+	return catalog.CatalogBinding{}
+}
+
+// getBindingName returns a kubernetes compatible name unique per release and binding,
+// long release names are shortened to leave room for the job suffixes within 63 characters
+func getBindingName(name string, bindingId string) string {
+	hash := sha1.Sum([]byte(bindingId))
+	return truncateName(name, maxBindingReleaseLength) + "-" + hex.EncodeToString(hash[:])[:10]
+}
+
+func newBindingValues(id string) (map[string]string, error) {
+	username, err := generator.String(nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := generator.String(nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		bindingValueId:       id,
+		bindingValueUsername: username,
+		bindingValuePassword: password,
+	}, nil
+}
+
+func runBindingAction(service catalog.CatalogService, plan catalog.CatalogPlan, actionName string, action *catalog.CatalogBindingAction, jobName string, name string, namespace string, lookup template.Lookup) error {
+	var command []string
+
+	for index, text := range action.Command {
+		value, err := renderTemplate(service, plan, "binding/"+actionName+"/command/"+strconv.Itoa(index), text, lookup)
+
+		if err != nil {
+			return err
+		}
+
+		command = append(command, value)
+	}
+
+	// the environment contains credentials, it is passed in a secret instead of the spec of the job
+	env := map[string]string{}
+
+	for key, text := range action.Env {
+		value, err := renderTemplate(service, plan, "binding/"+actionName+"/env/"+key, text, lookup)
+
+		if err != nil {
+			return err
+		}
+
+		env[key] = value
+	}
+
+	backoffLimit := 2
+
+	job := map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]interface{}{
+			"name":   jobName,
+			"labels": getBindingLabels(name),
+		},
+		"spec": map[string]interface{}{
+			"backoffLimit": backoffLimit,
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"labels": getBindingLabels(name),
+				},
+				"spec": map[string]interface{}{
+					"restartPolicy": "Never",
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name":    "binding",
+							"image":   action.Image,
+							"command": command,
+							"envFrom": []interface{}{
+								map[string]interface{}{
+									"secretRef": map[string]string{"name": jobName},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	manifest, err := json.Marshal(job)
+
+	if err != nil {
+		return err
+	}
+
+	timeout := action.Timeout
+
+	if len(timeout) == 0 {
+		timeout = defaultBindingTimeout
+	}
+
+	duration, err := time.ParseDuration(timeout)
+
+	if err != nil {
+		return err
+	}
+
+	// remove leftovers of a previous attempt, the job has to be gone before it is created again
+	deleteBindingJob(namespace, jobName)
+
+	err = kubectl.WaitForDeletion(namespace, "job", jobName, duration)
+
+	if err != nil {
+		return err
+	}
+
+	// the pod waits for the secret, which is created before the job
+	err = applyBindingSecret(namespace, jobName, name, env, nil)
+
+	if err != nil {
+		return err
+	}
+
+	err = kubectl.Apply(namespace, manifest)
+
+	if err != nil {
+		deleteBindingJob(namespace, jobName)
+		return err
+	}
+
+	// the secret is owned by the job, so kubernetes removes it with the job if helmi does not
+	owner, err := getJobOwnerReference(namespace, jobName)
+
+	if err == nil {
+		err = applyBindingSecret(namespace, jobName, name, env, owner)
+	}
+
+	if err == nil {
+		err = kubectl.WaitForJob(namespace, jobName, duration)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return deleteBindingJob(namespace, jobName)
+}
+
+// applyBindingSecret creates the secret with the environment of a binding job
+func applyBindingSecret(namespace string, jobName string, name string, env map[string]string, owner map[string]interface{}) error {
+	data := map[string]string{}
+
+	for key, value := range env {
+		data[key] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	metadata := map[string]interface{}{
+		"name":   jobName,
+		"labels": getBindingLabels(name),
+	}
+
+	if owner != nil {
+		metadata["ownerReferences"] = []interface{}{owner}
+	}
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "Opaque",
+		"metadata":   metadata,
+		"data":       data,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return kubectl.Apply(namespace, manifest)
+}
+
+// getJobOwnerReference returns the owner reference of a job for the resources it uses
+func getJobOwnerReference(namespace string, jobName string) (map[string]interface{}, error) {
+	output, err := kubectl.GetResource(namespace, "job", jobName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if output == nil {
+		return nil, errors.New("job " + jobName + " not found")
+	}
+
+	var job struct {
+		Metadata struct {
+			Uid string `json:"uid"`
+		} `json:"metadata"`
+	}
+
+	if err := json.Unmarshal(output, &job); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"name":       jobName,
+		"uid":        job.Metadata.Uid,
+	}, nil
+}
+
+// deleteBindingJob removes a binding job and its secret
+func deleteBindingJob(namespace string, jobName string) error {
+	err := kubectl.Delete(namespace, "job", jobName)
+
+	if secretErr := kubectl.Delete(namespace, "secret", jobName); err == nil {
+		err = secretErr
+	}
+
+	return err
+}
+
+func getBindingLabels(name string) map[string]string {
+	return map[string]string{
+		"heritage": "helmi",
+		"release":  name,
+	}
+}
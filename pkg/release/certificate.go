@@ -0,0 +1,167 @@
+package release
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/monostream/helmi/pkg/catalog"
+	"github.com/monostream/helmi/pkg/certificate"
+	"github.com/monostream/helmi/pkg/helm"
+	"github.com/monostream/helmi/pkg/kubectl"
+	"github.com/monostream/helmi/pkg/template"
+	"os"
+	"strings"
+)
+
+const tlsCa = "ca"
+const tlsCertificate = "cert"
+const tlsKey = "key"
+
+// releaseCertificate is the server certificate of a release and its key in PEM format
+type releaseCertificate struct {
+	Certificate string
+	Key         string
+}
+
+// getCertificateHosts returns the service names of a release, named <release>-<chart> like the helm
+// fullname, and the cluster address
+func getCertificateHosts(name string, namespace string, chart string) ([]string, error) {
+	namespace = helm.GetNamespace(namespace)
+	chartName := chart[strings.LastIndex(chart, "/")+1:]
+	fullName := name
+
+	if !strings.Contains(name, chartName) {
+		fullName = truncateName(name+"-"+chartName, 63)
+	}
+
+	hosts := []string{
+		fullName + "." + namespace + ".svc.cluster.local",
+		fullName + "." + namespace + ".svc",
+		fullName + "." + namespace,
+		fullName,
+	}
+
+	var nodes []kubectl.Node
+
+	// the nodes are only needed if the address is not set as dns name
+	if _, ok := os.LookupEnv("DOMAIN"); !ok {
+		var err error
+
+		if nodes, err = kubectl.GetNodes(); err != nil {
+			return nil, err
+		}
+	}
+
+	if address, _ := getClusterValue(nodes, helm.Status{}, "address"); len(address) > 0 {
+		hosts = append(hosts, address)
+	}
+
+	return hosts, nil
+}
+
+// getReleaseCertificate returns the certificate found in the existing values if it is still valid or issues a new one
+func getReleaseCertificate(service catalog.CatalogService, plan catalog.CatalogPlan, name string, namespace string, existingValues map[string]string) (releaseCertificate, error) {
+	authority, err := certificate.GetAuthority()
+
+	if err != nil {
+		return releaseCertificate{}, err
+	}
+
+	chart, err := getChart(service, plan)
+
+	if err != nil {
+		return releaseCertificate{}, err
+	}
+
+	hosts, err := getCertificateHosts(name, namespace, chart)
+
+	if err != nil {
+		return releaseCertificate{}, err
+	}
+
+	existing := findCertificate(service, plan, existingValues)
+
+	if authority.IsValid(existing.Certificate, existing.Key, hosts) {
+		return existing, nil
+	}
+
+	certificatePem, keyPem, err := authority.Issue(hosts)
+
+	if err != nil {
+		return releaseCertificate{}, err
+	}
+
+	return releaseCertificate{Certificate: certificatePem, Key: keyPem}, nil
+}
+
+// findCertificate reads the certificate and key from the values of the chart values which look them up,
+// chart values may encode them as base64
+func findCertificate(service catalog.CatalogService, plan catalog.CatalogPlan, values map[string]string) releaseCertificate {
+	var found releaseCertificate
+
+	templates := map[string]string{}
+
+	for key, value := range service.ChartValues {
+		templates[key] = value
+	}
+
+	for key, value := range plan.ChartValues {
+		templates[key] = value
+	}
+
+	for key, text := range templates {
+		calls, err := template.Parse("chart-value", text)
+
+		if err != nil || len(values[key]) == 0 {
+			continue
+		}
+
+		value := values[key]
+
+		if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+			value = string(decoded)
+		}
+
+		for _, call := range calls {
+			if !strings.EqualFold(call.Type, lookupTls) {
+				continue
+			}
+
+			switch call.Path {
+			case tlsCertificate:
+				found.Certificate = value
+			case tlsKey:
+				found.Key = value
+			}
+		}
+	}
+
+	return found
+}
+
+// getTlsValue returns the CA, the certificate or the key of a release, the certificate is only issued if needed
+func getTlsValue(path string, getCertificate func() (releaseCertificate, error)) (string, error) {
+	switch path {
+	case tlsCa:
+		authority, err := certificate.GetAuthority()
+
+		if err != nil {
+			return "", err
+		}
+
+		return authority.Certificate, nil
+	case tlsCertificate, tlsKey:
+		c, err := getCertificate()
+
+		if err != nil {
+			return "", err
+		}
+
+		if path == tlsKey {
+			return c.Key, nil
+		}
+
+		return c.Certificate, nil
+	}
+
+	return "", errors.New("unknown tls value " + path)
+}
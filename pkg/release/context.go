@@ -0,0 +1,133 @@
+package release
+
+import (
+	"errors"
+	"fmt"
+	"github.com/monostream/helmi/pkg/catalog"
+	"github.com/monostream/helmi/pkg/helm"
+	"regexp"
+	"strings"
+)
+
+var ErrPlanNotAvailable = errors.New("plan is not available for this platform or organization")
+
+// context fields of the platforms which are added as labels to the objects of a release
+var contextLabels = map[string]string{
+	"platform":          "helmi/platform",
+	"organization_guid": "helmi/organization-guid",
+	"space_guid":        "helmi/space-guid",
+	"namespace":         "helmi/namespace",
+	"clusterid":         "helmi/cluster-id",
+}
+
+// names may contain any character and are added as annotations
+var contextAnnotations = map[string]string{
+	"organization_name": "helmi/organization-name",
+	"space_name":        "helmi/space-name",
+	"instance_name":     "helmi/instance-name",
+}
+
+// kubernetes limits label values to 63 alphanumeric characters, dashes, underscores and dots
+const maxLabelLength = 63
+
+var labelValueRegex = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+
+// IsAvailable returns false if the restrictions of the service or plan exclude the platform or organization of the context
+func IsAvailable(catalog *catalog.Catalog, serviceId string, planId string, context map[string]interface{}) bool {
+	service, _ := catalog.GetService(serviceId)
+	plan, _ := catalog.GetServicePlan(serviceId, planId)
+
+	return isAvailable(service, plan, context)
+}
+
+func isAvailable(service catalog.CatalogService, plan catalog.CatalogPlan, context map[string]interface{}) bool {
+	restrictions := plan.Restrictions
+
+	if restrictions == nil {
+		restrictions = service.Restrictions
+	}
+
+	if restrictions == nil {
+		return true
+	}
+
+	if len(restrictions.Platforms) > 0 && !containsFold(restrictions.Platforms, getContextValue(context, "platform")) {
+		return false
+	}
+
+	if len(restrictions.Organizations) > 0 && !containsFold(restrictions.Organizations, getContextValue(context, "organization_guid")) {
+		return false
+	}
+
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getContextValue returns a field of the context, nested fields are separated by slashes
+func getContextValue(context map[string]interface{}, path string) string {
+	var value interface{} = context
+
+	for _, key := range strings.Split(path, "/") {
+		fields, ok := value.(map[string]interface{})
+
+		if !ok {
+			return ""
+		}
+
+		value = fields[key]
+	}
+
+	switch v := value.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// getContextMetadata returns the labels and annotations which identify the owner of a release
+func getContextMetadata(id string, context map[string]interface{}) helm.Metadata {
+	metadata := helm.Metadata{
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+	}
+
+	addLabel := func(key string, value string) {
+		if len(value) == 0 {
+			return
+		}
+
+		// values which are no valid labels are kept as annotations
+		if len(value) > maxLabelLength || !labelValueRegex.MatchString(value) {
+			metadata.Annotations[key] = value
+			return
+		}
+
+		metadata.Labels[key] = value
+	}
+
+	addLabel("helmi/instance-id", id)
+
+	for field, key := range contextLabels {
+		addLabel(key, getContextValue(context, field))
+	}
+
+	for field, key := range contextAnnotations {
+		if value := getContextValue(context, field); len(value) > 0 {
+			metadata.Annotations[key] = value
+		}
+	}
+
+	return metadata
+}
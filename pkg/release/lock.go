@@ -0,0 +1,41 @@
+package release
+
+import (
+	"sync"
+)
+
+// reserved holds the instances and bindings which a request of this broker is changing. The operation
+// in progress is only recorded in the store after it was checked, the reservation keeps concurrent
+// requests from passing the check at the same time.
+var reserved = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: map[string]bool{}}
+
+// reserve returns ErrOperationInProgress if another request is changing the instance or binding,
+// otherwise the returned function releases the reservation
+func reserve(key string) (func(), error) {
+	reserved.Lock()
+	defer reserved.Unlock()
+
+	if reserved.keys[key] {
+		return nil, ErrOperationInProgress
+	}
+
+	reserved.keys[key] = true
+
+	return func() {
+		reserved.Lock()
+		defer reserved.Unlock()
+
+		delete(reserved.keys, key)
+	}, nil
+}
+
+func getInstanceKey(id string) string {
+	return "instance/" + id
+}
+
+func getBindingKey(id string, bindingId string) string {
+	return "binding/" + id + "/" + bindingId
+}
@@ -0,0 +1,325 @@
+package release
+
+import (
+	"errors"
+	"github.com/monostream/helmi/pkg/catalog"
+	"github.com/monostream/helmi/pkg/generator"
+	"github.com/monostream/helmi/pkg/helm"
+	"github.com/monostream/helmi/pkg/kubectl"
+	"github.com/monostream/helmi/pkg/template"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const lookupValue = "value"
+const lookupCluster = "cluster"
+const lookupUsername = "username"
+const lookupPassword = "password"
+const lookupToken = "token"
+const lookupKey = "key"
+const lookupTls = "tls"
+const lookupEnv = "env"
+const lookupRelease = "release"
+const lookupBinding = "binding"
+const lookupContext = "context"
+
+// TemplateError is a template of the catalog which failed to render, the error names the key of the template
+type TemplateError struct {
+	Service string
+	Plan    string
+	Err     error
+}
+
+func (e TemplateError) Error() string {
+	return "service " + e.Service + ", plan " + e.Plan + ": " + e.Err.Error()
+}
+
+func renderTemplate(service catalog.CatalogService, plan catalog.CatalogPlan, key string, text string, lookup template.Lookup) (string, error) {
+	value, err := template.Render(key, text, lookup)
+
+	if err != nil {
+		return "", TemplateError{Service: service.Name, Plan: plan.Name, Err: err}
+	}
+
+	return value, nil
+}
+
+func getChartValues(service catalog.CatalogService, plan catalog.CatalogPlan, name string, namespace string, parameterValues map[string]string, existingValues map[string]string, context map[string]interface{}) (map[string]string, error) {
+	values := map[string]string{}
+	templates := map[string]string{}
+
+	for key, value := range service.ChartValues {
+		templates[key] = value
+	}
+
+	for key, value := range plan.ChartValues {
+		templates[key] = value
+	}
+
+	// generated values are kept per lookup type and path, so every lookup of a path returns the same value
+	generated := map[string]map[string]string{}
+
+	generate := func(lookupType string, path string, generator func() (string, error)) (string, error) {
+		if generated[lookupType] == nil {
+			generated[lookupType] = map[string]string{}
+		}
+
+		value := generated[lookupType][path]
+
+		if len(value) == 0 {
+			value = existingValues[path]
+		}
+
+		if len(value) == 0 {
+			var err error
+
+			if value, err = generator(); err != nil {
+				return "", err
+			}
+		}
+
+		generated[lookupType][path] = value
+
+		return value, nil
+	}
+
+	// the certificate is issued once for all lookups of its certificate and key
+	var issued *releaseCertificate
+
+	getCertificate := func() (releaseCertificate, error) {
+		if issued == nil {
+			c, err := getReleaseCertificate(service, plan, name, namespace, existingValues)
+
+			if err != nil {
+				return c, err
+			}
+
+			issued = &c
+		}
+
+		return *issued, nil
+	}
+
+	lookup := func(lookupType string, path string, options map[string]string) (string, error) {
+		lookupType = strings.ToLower(lookupType)
+
+		if err := generator.Check(lookupType, options); err != nil {
+			return "", err
+		}
+
+		switch lookupType {
+		case lookupUsername, lookupPassword:
+			return generate(lookupType, path, func() (string, error) {
+				return generator.String(options)
+			})
+		case lookupToken:
+			return generate(lookupType, path, func() (string, error) {
+				return generator.Token(options)
+			})
+		case lookupKey:
+			// the path names the chart value of the private key, the public key is derived from it
+			privateKey, err := generate(lookupType, path, func() (string, error) {
+				return generator.PrivateKey(options)
+			})
+
+			if err != nil {
+				return "", err
+			}
+
+			return getKeyPart(privateKey, options)
+		case lookupTls:
+			return getTlsValue(path, getCertificate)
+		case lookupEnv:
+			env, _ := os.LookupEnv(path)
+			return env, nil
+		case lookupContext:
+			return getContextValue(context, path), nil
+		}
+
+		return "", errors.New("lookup type " + lookupType + " is not available in chart values")
+	}
+
+	for key, text := range templates {
+		value, err := renderTemplate(service, plan, "chart-values/"+key, text, lookup)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(value) > 0 {
+			values[key] = value
+		}
+	}
+
+	for key, value := range parameterValues {
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func getUserCredentials(service catalog.CatalogService, plan catalog.CatalogPlan, kubernetesNodes []kubectl.Node, helmStatus helm.Status, helmValues map[string]string, bindingValues map[string]string, context map[string]interface{}) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	templates := map[string]interface{}{}
+
+	for key, value := range service.UserCredentials {
+		templates[key] = value
+	}
+
+	for key, value := range plan.UserCredentials {
+		templates[key] = value
+	}
+
+	lookup := getReleaseLookup(service, plan, kubernetesNodes, helmStatus, helmValues, bindingValues, context)
+
+	for key, templateInterface := range templates {
+		switch t := templateInterface.(type) {
+		case string:
+			value, err := renderTemplate(service, plan, "user-credentials/"+key, t, lookup)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if len(value) > 0 {
+				values[key] = value
+			}
+		case []interface{}:
+			valueArray := []string{}
+
+			for index, templateValue := range t {
+				text, ok := templateValue.(string)
+
+				if !ok {
+					continue
+				}
+
+				value, err := renderTemplate(service, plan, "user-credentials/"+key+"/"+strconv.Itoa(index), text, lookup)
+
+				if err != nil {
+					return nil, err
+				}
+
+				if len(value) > 0 {
+					valueArray = append(valueArray, value)
+				}
+			}
+
+			if len(valueArray) > 0 {
+				values[key] = valueArray
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// getReleaseLookup resolves the lookups of credentials, binding jobs and dashboards of a running release
+func getReleaseLookup(service catalog.CatalogService, plan catalog.CatalogPlan, kubernetesNodes []kubectl.Node, helmStatus helm.Status, helmValues map[string]string, bindingValues map[string]string, context map[string]interface{}) template.Lookup {
+	return func(lookupType string, path string, options map[string]string) (string, error) {
+		switch strings.ToLower(lookupType) {
+		case lookupBinding:
+			return bindingValues[path], nil
+		case lookupContext:
+			return getContextValue(context, path), nil
+		case lookupUsername, lookupPassword, lookupToken, lookupValue:
+			return helmValues[path], nil
+		case lookupKey:
+			if len(helmValues[path]) == 0 {
+				return "", nil
+			}
+
+			return getKeyPart(helmValues[path], options)
+		case lookupTls:
+			// the certificate of a release is never issued again outside of its chart values
+			return getTlsValue(path, func() (releaseCertificate, error) {
+				return findCertificate(service, plan, helmValues), nil
+			})
+		case lookupRelease:
+			return getReleaseValue(helmStatus, path)
+		case lookupCluster:
+			return getClusterValue(kubernetesNodes, helmStatus, path)
+		}
+
+		return "", errors.New("unknown lookup type " + lookupType)
+	}
+}
+
+// getKeyPart returns the private key or its public key if the option part is public
+func getKeyPart(privateKey string, options map[string]string) (string, error) {
+	if options["part"] == "public" {
+		return generator.PublicKey(privateKey, options)
+	}
+
+	return privateKey, nil
+}
+
+func getReleaseValue(helmStatus helm.Status, path string) (string, error) {
+	switch strings.ToLower(path) {
+	case "name":
+		return helmStatus.Name, nil
+	case "namespace":
+		return helmStatus.Namespace, nil
+	case "ingress":
+		if len(helmStatus.IngressHosts) > 0 {
+			return helmStatus.IngressHosts[0], nil
+		}
+
+		return "", nil
+	}
+
+	return "", errors.New("unknown release value " + path)
+}
+
+func getClusterValue(kubernetesNodes []kubectl.Node, helmStatus helm.Status, path string) (string, error) {
+	if strings.HasPrefix(strings.ToLower(path), "port") {
+		portParts := strings.Split(path, ":")
+
+		for clusterPort, nodePort := range helmStatus.NodePorts {
+			if len(portParts) == 1 || strings.EqualFold(strconv.Itoa(clusterPort), portParts[1]) {
+				return strconv.Itoa(nodePort), nil
+			}
+		}
+
+		// services without node port are reached on their cluster port
+		if len(portParts) == 1 {
+			return "", nil
+		}
+
+		return portParts[1], nil
+	}
+
+	switch strings.ToLower(path) {
+	// single host
+	case "address":
+		// return dns name if set as environment variable
+		if value, ok := os.LookupEnv("DOMAIN"); ok {
+			return value, nil
+		}
+
+		for _, node := range kubernetesNodes {
+			if len(node.ExternalIP) > 0 {
+				return node.ExternalIP, nil
+			}
+		}
+
+		for _, node := range kubernetesNodes {
+			if len(node.InternalIP) > 0 {
+				return node.InternalIP, nil
+			}
+		}
+
+		return "", nil
+	case "hostname":
+		for _, node := range kubernetesNodes {
+			if len(node.Hostname) > 0 {
+				return node.Hostname, nil
+			}
+		}
+
+		return "", nil
+	}
+
+	return "", errors.New("unknown cluster value " + path)
+}
@@ -0,0 +1,253 @@
+package release
+
+import (
+	"encoding/json"
+	"github.com/monostream/helmi/pkg/catalog"
+	"github.com/monostream/helmi/pkg/helm"
+	"github.com/monostream/helmi/pkg/kubectl"
+	"go.uber.org/zap"
+	"regexp"
+	"strings"
+)
+
+const namespaceFixed = "fixed"
+const namespaceInstance = "instance"
+const namespaceContext = "context"
+
+// kubernetes limits namespaces to dns labels
+const maxNamespaceLength = 63
+
+func getNamespaceSettings(service catalog.CatalogService, plan catalog.CatalogPlan) *catalog.CatalogNamespace {
+	if plan.Namespace != nil {
+		return plan.Namespace
+	}
+
+	return service.Namespace
+}
+
+// getNamespace returns the namespace of a new release, empty for the default namespace of helm
+func getNamespace(settings *catalog.CatalogNamespace, name string, context map[string]interface{}) string {
+	if settings == nil {
+		return ""
+	}
+
+	switch strings.ToLower(settings.Strategy) {
+	case namespaceInstance:
+		return sanitizeNamespace(settings.Prefix + name)
+	case namespaceContext:
+		if namespace := getContextNamespace(settings, context); len(namespace) > 0 {
+			return namespace
+		}
+	}
+
+	return sanitizeNamespace(settings.Name)
+}
+
+// getContextNamespace derives the namespace from the osb context of the platform
+func getContextNamespace(settings *catalog.CatalogNamespace, context map[string]interface{}) string {
+	platform, _ := context["platform"].(string)
+
+	switch strings.ToLower(platform) {
+	case "kubernetes":
+		namespace, _ := context["namespace"].(string)
+		return sanitizeNamespace(namespace)
+	case "cloudfoundry":
+		if space, _ := context["space_guid"].(string); len(space) > 0 {
+			return sanitizeNamespace(settings.Prefix + space)
+		}
+
+		if organization, _ := context["organization_guid"].(string); len(organization) > 0 {
+			return sanitizeNamespace(settings.Prefix + organization)
+		}
+	}
+
+	return ""
+}
+
+func sanitizeNamespace(value string) string {
+	namespace := regexp.MustCompile(`[^a-z0-9-]+`).ReplaceAllString(strings.ToLower(value), "-")
+
+	if len(namespace) > maxNamespaceLength {
+		namespace = namespace[:maxNamespaceLength]
+	}
+
+	return strings.Trim(namespace, "-")
+}
+
+// isOwnedNamespace returns true if the namespace belongs to a single instance and is deleted with it
+func isOwnedNamespace(settings *catalog.CatalogNamespace) bool {
+	return settings != nil && strings.EqualFold(settings.Strategy, namespaceInstance)
+}
+
+// prepareNamespace creates a missing namespace and applies its quota and policies,
+// it returns true if the namespace was created
+func prepareNamespace(settings *catalog.CatalogNamespace, namespace string, name string, metadata helm.Metadata) (bool, error) {
+	if settings == nil || len(namespace) == 0 {
+		return false, nil
+	}
+
+	existing, err := kubectl.GetResource("", "namespace", namespace)
+
+	if err != nil {
+		return false, err
+	}
+
+	created := false
+
+	if existing == nil {
+		if err := applyManifest("", getNamespaceManifest(settings, namespace, name, metadata)); err != nil {
+			return false, err
+		}
+
+		created = true
+	}
+
+	for _, manifest := range getNamespacePolicyManifests(settings, namespace) {
+		if err := applyManifest(namespace, manifest); err != nil {
+			return created, err
+		}
+	}
+
+	return created, nil
+}
+
+// deleteNamespace removes a namespace created for a single instance together with everything left in it
+func deleteNamespace(id string, namespace string) error {
+	err := kubectl.Delete("", "namespace", namespace)
+
+	if err != nil {
+		getLogger().Error("failed to delete namespace",
+			zap.String("id", id),
+			zap.String("namespace", namespace),
+			zap.Error(err))
+	}
+
+	return err
+}
+
+func applyManifest(namespace string, resource map[string]interface{}) error {
+	manifest, err := json.Marshal(resource)
+
+	if err != nil {
+		return err
+	}
+
+	return kubectl.Apply(namespace, manifest)
+}
+
+func getNamespaceManifest(settings *catalog.CatalogNamespace, namespace string, name string, metadata helm.Metadata) map[string]interface{} {
+	labels := map[string]string{}
+	annotations := map[string]string{}
+
+	// only namespaces of a single instance carry its context, shared namespaces outlive the instance which created them
+	if isOwnedNamespace(settings) {
+		for key, value := range metadata.Labels {
+			labels[key] = value
+		}
+
+		for key, value := range metadata.Annotations {
+			annotations[key] = value
+		}
+
+		labels["release"] = name
+	}
+
+	for key, value := range settings.Labels {
+		labels[key] = value
+	}
+
+	labels["heritage"] = "helmi"
+
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata": map[string]interface{}{
+			"name":        namespace,
+			"labels":      labels,
+			"annotations": annotations,
+		},
+	}
+}
+
+func getNamespacePolicyManifests(settings *catalog.CatalogNamespace, namespace string) []map[string]interface{} {
+	var manifests []map[string]interface{}
+
+	metadata := map[string]interface{}{
+		"name":      "helmi",
+		"namespace": namespace,
+		"labels":    map[string]string{"heritage": "helmi"},
+	}
+
+	if len(settings.ResourceQuota) > 0 {
+		manifests = append(manifests, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ResourceQuota",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"hard": settings.ResourceQuota,
+			},
+		})
+	}
+
+	if settings.LimitRange != nil {
+		limit := map[string]interface{}{
+			"type": "Container",
+		}
+
+		if len(settings.LimitRange.Default) > 0 {
+			limit["default"] = settings.LimitRange.Default
+		}
+
+		if len(settings.LimitRange.DefaultRequest) > 0 {
+			limit["defaultRequest"] = settings.LimitRange.DefaultRequest
+		}
+
+		if len(settings.LimitRange.Max) > 0 {
+			limit["max"] = settings.LimitRange.Max
+		}
+
+		manifests = append(manifests, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "LimitRange",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"limits": []interface{}{limit},
+			},
+		})
+	}
+
+	// pods of other namespaces managed by helmi are denied, applications outside of them can still connect
+	if settings.NetworkPolicy {
+		manifests = append(manifests, map[string]interface{}{
+			"apiVersion": "networking.k8s.io/v1",
+			"kind":       "NetworkPolicy",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"podSelector": map[string]interface{}{},
+				"policyTypes": []string{"Ingress"},
+				"ingress": []interface{}{
+					map[string]interface{}{
+						"from": []interface{}{
+							map[string]interface{}{
+								"podSelector": map[string]interface{}{},
+							},
+							map[string]interface{}{
+								"namespaceSelector": map[string]interface{}{
+									"matchExpressions": []interface{}{
+										map[string]interface{}{
+											"key":      "heritage",
+											"operator": "NotIn",
+											"values":   []string{"helmi"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	return manifests
+}
@@ -0,0 +1,152 @@
+package release
+
+import (
+	"errors"
+	"github.com/monostream/helmi/pkg/catalog"
+	"github.com/monostream/helmi/pkg/helm"
+	"github.com/monostream/helmi/pkg/store"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidId = errors.New("ids may only contain letters, digits, dots, dashes and underscores")
+
+// names of the legacy naming
+const namePrefix = "helmi"
+const shortIdLength = 14
+
+// the default template keeps the names of releases installed by earlier versions
+const defaultNameTemplate = namePrefix + "{{shortid}}"
+
+// helm limits release names to 53 characters, which leaves room for suffixes of chart resources within dns labels
+const maxNameLength = 53
+
+// number of suffixes tried if a release with the name already exists
+const maxNameAttempts = 10
+
+var idRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+var nameTemplateRegex = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+var nameRegex = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// ValidateId checks an instance or binding id of a request
+func ValidateId(id string) error {
+	if !idRegex.MatchString(id) {
+		return ErrInvalidId
+	}
+
+	return nil
+}
+
+// getReleaseName returns the name recorded for an instance, releases unknown to the store use the legacy name
+func getReleaseName(stateStore store.Store, id string) string {
+	instance, err := stateStore.GetInstance(id)
+
+	if err != nil || instance == nil || len(instance.ReleaseName) == 0 {
+		return getName(id)
+	}
+
+	return instance.ReleaseName
+}
+
+// newReleaseName renders the name template of the service and adds a suffix while a release with the name exists
+func newReleaseName(service catalog.CatalogService, plan catalog.CatalogPlan, id string, settings *catalog.CatalogNamespace, context map[string]interface{}) (string, string, error) {
+	name, err := renderName(getNameTemplate(service), service, plan, id)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	for attempt := 1; attempt <= maxNameAttempts; attempt++ {
+		candidate := name
+
+		if attempt > 1 {
+			candidate = truncateName(name, maxNameLength-len(strconv.Itoa(attempt))-1) + "-" + strconv.Itoa(attempt)
+		}
+
+		namespace := getNamespace(settings, candidate, context)
+
+		exists, err := helm.Exists(candidate, namespace)
+
+		if err != nil {
+			return "", "", err
+		}
+
+		if !exists {
+			return candidate, namespace, nil
+		}
+	}
+
+	return "", "", errors.New("no free release name for " + name)
+}
+
+// getNameTemplate returns the template of the service or the RELEASE_NAME environment variable
+func getNameTemplate(service catalog.CatalogService) string {
+	if len(service.ReleaseName) > 0 {
+		return service.ReleaseName
+	}
+
+	if template, exists := os.LookupEnv("RELEASE_NAME"); exists && len(template) > 0 {
+		return template
+	}
+
+	return defaultNameTemplate
+}
+
+// renderName replaces {{service}}, {{plan}}, {{id}} and {{shortid}} and returns a valid release name
+func renderName(template string, service catalog.CatalogService, plan catalog.CatalogPlan, id string) (string, error) {
+	var err error
+
+	rendered := nameTemplateRegex.ReplaceAllStringFunc(template, func(m string) string {
+		switch strings.ToLower(nameTemplateRegex.FindStringSubmatch(m)[1]) {
+		case "service":
+			return service.Name
+		case "plan":
+			return plan.Name
+		case "id":
+			return getCompactId(id)
+		case "shortid":
+			return getShortId(id)
+		}
+
+		err = errors.New("unknown placeholder " + m + " in release name " + template)
+		return ""
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	name := truncateName(nameRegex.ReplaceAllString(strings.ToLower(rendered), "-"), maxNameLength)
+
+	// releases must start with a letter to be valid dns labels
+	if len(name) == 0 || name[0] < 'a' || name[0] > 'z' {
+		name = truncateName(namePrefix+name, maxNameLength)
+	}
+
+	return name, nil
+}
+
+func truncateName(name string, length int) string {
+	if len(name) > length {
+		name = name[:length]
+	}
+
+	return strings.Trim(name, "-")
+}
+
+// getCompactId returns the id in lower case without separators
+func getCompactId(id string) string {
+	return regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(id), "")
+}
+
+func getShortId(id string) string {
+	compact := getCompactId(id)
+
+	if len(compact) > shortIdLength {
+		return compact[:shortIdLength]
+	}
+
+	return compact
+}
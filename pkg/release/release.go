@@ -2,34 +2,53 @@ package release
 
 import (
 	"errors"
-	"regexp"
+	"sort"
 	"strings"
 	"strconv"
 	"go.uber.org/zap"
-	"github.com/satori/go.uuid"
 	"github.com/monostream/helmi/pkg/helm"
 	"github.com/monostream/helmi/pkg/kubectl"
 	"github.com/monostream/helmi/pkg/catalog"
+	"github.com/monostream/helmi/pkg/schema"
+	"github.com/monostream/helmi/pkg/store"
+	"github.com/monostream/helmi/pkg/template"
 	"go.uber.org/zap/zapcore"
-	"os"
+	"fmt"
 	"reflect"
 )
 
-const lookupRegex = `\{\{\s*lookup\s*\(\s*'(?P<type>[\w]+)'\s*,\s*'(?P<path>[\w/:]+)'\s*\)\s*\}\}`
-const lookupRegexType = "type"
-const lookupRegexPath = "path"
+var ErrInstanceNotFound = errors.New("service instance not found")
+var ErrInstanceExists = errors.New("service instance already exists")
+var ErrOperationInProgress = errors.New("another operation is in progress")
+var ErrProvisionFailed = errors.New("service instance provisioning failed")
 
-const lookupValue    = "value"
-const lookupCluster  = "cluster"
-const lookupUsername = "username"
-const lookupPassword = "password"
-const lookupEnv      = "env"
-const lookupRelease  = "release"
+// Comparison is the result of comparing a request with an existing instance or binding
+type Comparison int
+
+const (
+	NotFound Comparison = iota
+	Identical
+	InProgress
+	Conflicting
+)
+
+type ParameterError struct {
+	Violations []string
+}
+
+func (e ParameterError) Error() string {
+	return strings.Join(e.Violations, "; ")
+}
 
 type Status struct {
 	IsFailed    bool
 	IsDeployed  bool
 	IsAvailable bool
+
+	Revision int
+
+	DesiredNodes   int
+	AvailableNodes int
 }
 
 func getLogger() *zap.Logger {
@@ -43,8 +62,7 @@ func getLogger() *zap.Logger {
 	return logger
 }
 
-func Install(catalog *catalog.Catalog, serviceId string, planId string, id string, acceptsIncomplete bool) error {
-	name := getName(id)
+func Install(catalog *catalog.Catalog, stateStore store.Store, serviceId string, planId string, id string, parameters map[string]interface{}, context map[string]interface{}, acceptsIncomplete bool) (*store.Operation, error) {
 	logger := getLogger()
 
 	service, _ := catalog.GetService(serviceId)
@@ -52,39 +70,288 @@ func Install(catalog *catalog.Catalog, serviceId string, planId string, id strin
 
 	chart, chartErr := getChart(service, plan)
 	chartVersion, chartVersionErr := getChartVersion(service, plan)
-	chartValues := getChartValues(service, plan)
+	parameterValues, parameterErr := getParameterValues(service, plan, parameters)
 
 	if chartErr != nil {
 		logger.Error("failed to install release",
 			zap.String("id", id),
-			zap.String("name", name),
 			zap.String("serviceId", serviceId),
 			zap.String("planId", planId),
 			zap.Error(chartErr))
 
-		return chartErr
+		return nil, chartErr
+	}
+
+	if parameterErr != nil {
+		logger.Error("failed to install release",
+			zap.String("id", id),
+			zap.String("serviceId", serviceId),
+			zap.String("planId", planId),
+			zap.Error(parameterErr))
+
+		return nil, parameterErr
 	}
 
+	if !isAvailable(service, plan, context) {
+		return nil, ErrPlanNotAvailable
+	}
+
+	metadata := getContextMetadata(id, context)
+
 	if chartVersionErr != nil {
 		chartVersion = ""
 	}
 
-	err := helm.Install(name, chart, chartVersion, chartValues, acceptsIncomplete)
+	done, err := reserve(getInstanceKey(id))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+
+	instance, err := stateStore.GetInstance(id)
+
+	if err != nil {
+		logger.Error("failed to read instance from store",
+			zap.String("id", id),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	// never touch releases which were not installed by this request
+	if instance != nil {
+		return nil, ErrInstanceExists
+	}
+
+	namespaceSettings := getNamespaceSettings(service, plan)
+	name, namespace, err := newReleaseName(service, plan, id, namespaceSettings, context)
+
+	if err != nil {
+		logger.Error("failed to name release",
+			zap.String("id", id),
+			zap.String("serviceId", serviceId),
+			zap.String("planId", planId),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	// certificates are issued for the name and namespace of the release
+	chartValues, err := getChartValues(service, plan, name, namespace, parameterValues, nil, context)
+
+	if err != nil {
+		logger.Error("failed to render chart values",
+			zap.String("id", id),
+			zap.String("serviceId", serviceId),
+			zap.String("planId", planId),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	instance = &store.Instance{
+		Id:          id,
+		ServiceId:   serviceId,
+		PlanId:      planId,
+		ReleaseName: name,
+		Namespace:   namespace,
+
+		Parameters: parameters,
+		Context:    context,
+	}
+
+	operation := instance.StartOperation(store.OperationProvision)
+
+	if err := saveInstance(stateStore, instance); err != nil {
+		return nil, err
+	}
+
+	created, err := prepareNamespace(namespaceSettings, namespace, name, metadata)
+
+	instance.NamespaceCreated = created && isOwnedNamespace(namespaceSettings)
+
+	if err == nil {
+		err = helm.Install(name, namespace, chart, chartVersion, chartValues, metadata, acceptsIncomplete)
+	}
+
+	// asynchronous installs finish when the release becomes available
+	if err != nil || !acceptsIncomplete {
+		operation.Finish(err)
+	}
+
+	if saveErr := saveInstance(stateStore, instance); saveErr != nil && err == nil {
+		err = saveErr
+	}
 
 	if err != nil {
 		logger.Error("failed to install release",
 			zap.String("id", id),
 			zap.String("name", name),
+			zap.String("namespace", namespace),
 			zap.String("chart", chart),
 			zap.String("chart-version", chartVersion),
 			zap.String("serviceId", serviceId),
 			zap.String("planId", planId),
 			zap.Error(err))
 
-		return err
+		return nil, err
 	}
 
 	logger.Info("new release installed",
+		zap.String("id", id),
+		zap.String("name", name),
+		zap.String("namespace", namespace),
+		zap.String("chart", chart),
+		zap.String("chart-version", chartVersion),
+		zap.String("serviceId", serviceId),
+		zap.String("planId", planId))
+
+	return operation, nil
+}
+
+func Update(catalog *catalog.Catalog, stateStore store.Store, serviceId string, planId string, id string, parameters map[string]interface{}, acceptsIncomplete bool) (*store.Operation, error) {
+	name := getReleaseName(stateStore, id)
+	logger := getLogger()
+
+	service, _ := catalog.GetService(serviceId)
+	plan, _ := catalog.GetServicePlan(serviceId, planId)
+
+	done, err := reserve(getInstanceKey(id))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+
+	instance, err := stateStore.GetInstance(id)
+
+	if err != nil {
+		logger.Error("failed to read instance from store",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	if instance != nil && hasOperationInProgress(stateStore, instance) {
+		return nil, ErrOperationInProgress
+	}
+
+	// releases installed before the store existed
+	if instance == nil {
+		instance = &store.Instance{
+			Id:          id,
+			ServiceId:   serviceId,
+			ReleaseName: name,
+		}
+	}
+
+	// parameters of an update only change what is passed
+	mergedParameters := map[string]interface{}{}
+
+	for key, value := range instance.Parameters {
+		mergedParameters[key] = value
+	}
+
+	for key, value := range parameters {
+		mergedParameters[key] = value
+	}
+
+	chart, chartErr := getChart(service, plan)
+	chartVersion, chartVersionErr := getChartVersion(service, plan)
+	parameterValues, parameterErr := getParameterValues(service, plan, mergedParameters)
+
+	if chartErr != nil {
+		logger.Error("failed to update release",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.String("serviceId", serviceId),
+			zap.String("planId", planId),
+			zap.Error(chartErr))
+
+		return nil, chartErr
+	}
+
+	if parameterErr != nil {
+		logger.Error("failed to update release",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.String("serviceId", serviceId),
+			zap.String("planId", planId),
+			zap.Error(parameterErr))
+
+		return nil, parameterErr
+	}
+
+	if chartVersionErr != nil {
+		chartVersion = ""
+	}
+
+	if !isAvailable(service, plan, instance.Context) {
+		return nil, ErrPlanNotAvailable
+	}
+
+	// keep generated usernames and passwords of the running release
+	existingValues, err := helm.GetValues(name, instance.Namespace)
+
+	if err != nil {
+		logger.Error("failed to get helm values",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	chartValues, err := getChartValues(service, plan, name, instance.Namespace, parameterValues, existingValues, instance.Context)
+
+	if err != nil {
+		logger.Error("failed to render chart values",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	operation := instance.StartOperation(store.OperationUpdate)
+
+	err = helm.Upgrade(name, instance.Namespace, chart, chartVersion, chartValues, getContextMetadata(id, instance.Context), acceptsIncomplete)
+
+	if err != nil || !acceptsIncomplete {
+		operation.Finish(err)
+	} else if status, statusErr := helm.GetStatus(name, instance.Namespace); statusErr == nil {
+		// the update is finished once the new revision rolled out
+		operation.Revision = status.Revision
+	}
+
+	if err == nil {
+		instance.PlanId = planId
+		instance.Parameters = mergedParameters
+	}
+
+	if saveErr := saveInstance(stateStore, instance); saveErr != nil && err == nil {
+		err = saveErr
+	}
+
+	if err != nil {
+		logger.Error("failed to update release",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.String("chart", chart),
+			zap.String("chart-version", chartVersion),
+			zap.String("serviceId", serviceId),
+			zap.String("planId", planId),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	logger.Info("release updated",
 		zap.String("id", id),
 		zap.String("name", name),
 		zap.String("chart", chart),
@@ -92,14 +359,86 @@ func Install(catalog *catalog.Catalog, serviceId string, planId string, id strin
 		zap.String("serviceId", serviceId),
 		zap.String("planId", planId))
 
+	return operation, nil
+}
+
+func ValidateParameters(catalog *catalog.Catalog, serviceId string, planId string, parameters map[string]interface{}) error {
+	service, _ := catalog.GetService(serviceId)
+	plan, _ := catalog.GetServicePlan(serviceId, planId)
+
+	return validateParameters(service, plan, plan.Schemas.GetInstanceCreateSchema(), parameters)
+}
+
+func ValidateUpdateParameters(catalog *catalog.Catalog, serviceId string, planId string, parameters map[string]interface{}) error {
+	service, _ := catalog.GetService(serviceId)
+	plan, _ := catalog.GetServicePlan(serviceId, planId)
+
+	return validateParameters(service, plan, plan.Schemas.GetInstanceUpdateSchema(), parameters)
+}
+
+func ValidateBindingParameters(catalog *catalog.Catalog, serviceId string, planId string, parameters map[string]interface{}) error {
+	plan, _ := catalog.GetServicePlan(serviceId, planId)
+
+	violations := getSchemaViolations(plan.Schemas.GetBindingCreateSchema(), parameters)
+
+	if len(violations) > 0 {
+		return ParameterError{Violations: violations}
+	}
+
 	return nil
 }
 
-func Exists(id string) (bool, error) {
-	name := getName(id)
+// RequiresAsync returns true if the plan only supports asynchronous operations
+func RequiresAsync(catalog *catalog.Catalog, serviceId string, planId string) bool {
+	service, _ := catalog.GetService(serviceId)
+	plan, _ := catalog.GetServicePlan(serviceId, planId)
+
+	return service.AsyncOnly || plan.AsyncOnly
+}
+
+// CompareInstance checks a provision request against an already existing instance with the same id.
+// Instances unknown to the store are compared with the values of their helm release.
+func CompareInstance(catalog *catalog.Catalog, stateStore store.Store, serviceId string, planId string, id string, parameters map[string]interface{}) (Comparison, error) {
+	name := getReleaseName(stateStore, id)
+	logger := getLogger()
+
+	instance, err := stateStore.GetInstance(id)
+
+	if err != nil {
+		logger.Error("failed to read instance from store",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.Error(err))
+
+		return NotFound, err
+	}
+
+	if instance == nil {
+		return compareRelease(catalog, stateStore, serviceId, planId, id, parameters)
+	}
+
+	if !isIdentical(instance.ServiceId, instance.PlanId, instance.Parameters, serviceId, planId, parameters) {
+		return Conflicting, nil
+	}
+
+	if operation := instance.LastOperation(); operation != nil && operation.Type == store.OperationProvision {
+		if operation.State == store.StateFailed {
+			return Identical, ErrProvisionFailed
+		}
+
+		if hasOperationInProgress(stateStore, instance) {
+			return InProgress, nil
+		}
+	}
+
+	return Identical, nil
+}
+
+func Exists(stateStore store.Store, id string) (bool, error) {
+	name := getReleaseName(stateStore, id)
 	logger := getLogger()
 
-	exists, err := helm.Exists(name)
+	exists, err := helm.Exists(name, getReleaseNamespace(stateStore, id))
 
 	if err != nil {
 		logger.Error("failed to check if release exists",
@@ -111,21 +450,91 @@ func Exists(id string) (bool, error) {
 	return exists, err
 }
 
-func Delete(id string) error {
-	name := getName(id)
+// Delete removes the release of an instance, asynchronous deletes keep the instance
+// with a deprovision operation in the store until the release is gone
+func Delete(stateStore store.Store, id string, acceptsIncomplete bool) (*store.Operation, error) {
+	name := getReleaseName(stateStore, id)
+	logger := getLogger()
+
+	done, err := reserve(getInstanceKey(id))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+
+	instance, err := stateStore.GetInstance(id)
+
+	if err != nil {
+		logger.Error("failed to read instance from store",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	if instance != nil && hasOperationInProgress(stateStore, instance) {
+		return nil, ErrOperationInProgress
+	}
+
+	// releases installed before the store existed are deleted synchronously
+	if instance == nil {
+		return nil, deleteRelease(stateStore, id, nil)
+	}
+
+	operation := instance.StartOperation(store.OperationDeprovision)
+
+	if !acceptsIncomplete {
+		return operation, deleteRelease(stateStore, id, instance)
+	}
+
+	if err := saveInstance(stateStore, instance); err != nil {
+		return nil, err
+	}
+
+	go deleteRelease(stateStore, id, instance)
+
+	return operation, nil
+}
+
+// deleteRelease deletes the release and the instance or records the failed deprovision operation
+func deleteRelease(stateStore store.Store, id string, instance *store.Instance) error {
+	name := getReleaseName(stateStore, id)
 	logger := getLogger()
 
-	err := helm.Delete(name)
+	namespace := ""
+
+	if instance != nil {
+		namespace = instance.Namespace
+	}
+
+	// the namespace is only removed once the release is gone
+	finish := func() error {
+		if instance != nil && instance.NamespaceCreated {
+			if err := deleteNamespace(id, namespace); err != nil {
+				instance.LastOperation().Finish(err)
+				saveInstance(stateStore, instance)
+
+				return err
+			}
+		}
+
+		return deleteInstance(stateStore, id)
+	}
+
+	err := helm.Delete(name, namespace)
 
 	if err != nil {
-		exists, existsErr := helm.Exists(name)
+		exists, existsErr := helm.Exists(name, namespace)
 
 		if existsErr == nil && !exists {
 			logger.Info("release deleted (not existed)",
 				zap.String("id", id),
 				zap.String("name", name))
 
-			return nil
+			return finish()
 		}
 
 		logger.Error("failed to delete release",
@@ -133,6 +542,15 @@ func Delete(id string) error {
 			zap.String("name", name),
 			zap.Error(err))
 
+		if instance != nil {
+			instance.LastOperation().Finish(err)
+			saveInstance(stateStore, instance)
+		}
+
+		return err
+	}
+
+	if err := finish(); err != nil {
 		return err
 	}
 
@@ -143,14 +561,16 @@ func Delete(id string) error {
 	return nil
 }
 
-func GetStatus(id string) (Status, error) {
-	name := getName(id)
+// GetStatus reads the status of a release, operations are only finished by GetOperation
+func GetStatus(stateStore store.Store, id string) (Status, error) {
+	name := getReleaseName(stateStore, id)
+	namespace := getReleaseNamespace(stateStore, id)
 	logger := getLogger()
 
-	status, err := helm.GetStatus(name)
+	status, err := helm.GetStatus(name, namespace)
 
 	if err != nil {
-		exists, existsErr := helm.Exists(name)
+		exists, existsErr := helm.Exists(name, namespace)
 
 		if existsErr == nil && !exists {
 			logger.Info("asked status for deleted release",
@@ -172,31 +592,158 @@ func GetStatus(id string) (Status, error) {
 		zap.String("id", id),
 		zap.String("name", name))
 
-	return Status{
+	releaseStatus := Status{
 		IsFailed:    status.IsFailed,
 		IsDeployed:  status.IsDeployed,
-		IsAvailable: status.AvailableNodes >= status.DesiredNodes,
-	}, nil
+		IsAvailable: status.IsReady(),
+
+		Revision: status.Revision,
+
+		DesiredNodes:   status.DesiredNodes,
+		AvailableNodes: status.AvailableNodes,
+	}
+
+	return releaseStatus, nil
+}
+
+// GetOperation returns an operation of an instance and refreshes it while it is running,
+// without an operation id the last operation is returned
+func GetOperation(stateStore store.Store, id string, operationId string) (*store.Operation, error) {
+	name := getReleaseName(stateStore, id)
+	logger := getLogger()
+
+	instance, err := stateStore.GetInstance(id)
+
+	if err != nil {
+		logger.Error("failed to read instance from store",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	// releases installed before the store existed only report their status
+	if instance == nil {
+		return getReleaseOperation(stateStore, id)
+	}
+
+	operation := getInstanceOperation(instance, operationId)
+
+	if operation == nil {
+		return nil, ErrOperationNotFound
+	}
+
+	if operation.State != store.StateInProgress || operation.Type == store.OperationDeprovision {
+		return operation, nil
+	}
+
+	status, err := GetStatus(stateStore, id)
+
+	if err != nil {
+		return operation, nil
+	}
+
+	// running operations are only finished here, when the platform polls them
+	if finishOperation(operation, status) {
+		saveInstance(stateStore, instance)
+	}
+
+	if operation.State != store.StateFailed {
+		operation.Description = getStatusDescription(status)
+	}
+
+	return operation, nil
 }
 
-func GetCredentials(catalog *catalog.Catalog, serviceId string, planId string, id string) (map[string]interface{}, error) {
-	name := getName(id)
+func GetCredentials(catalog *catalog.Catalog, stateStore store.Store, serviceId string, planId string, id string) (map[string]interface{}, error) {
+	name := getReleaseName(stateStore, id)
 	logger := getLogger()
 
+	serviceId, planId, err := getInstanceIds(stateStore, id, serviceId, planId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	service, _ := catalog.GetService(serviceId)
+	plan, _ := catalog.GetServicePlan(serviceId, planId)
+
+	status, nodes, values, context, err := getLookupSources(stateStore, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := getUserCredentials(service, plan, nodes, status, values, nil, context)
+
+	if err != nil {
+		logger.Error("failed to render credentials",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.Error(err))
+
+		return nil, err
+	}
+
+	logger.Debug("sending release credentials",
+		zap.String("id", id),
+		zap.String("name", name))
+
+	return credentials, nil
+}
+
+// GetDashboardUrl resolves the dashboard url of the plan or service, empty if none is defined
+func GetDashboardUrl(catalog *catalog.Catalog, stateStore store.Store, serviceId string, planId string, id string) (string, error) {
+	serviceId, planId, err := getInstanceIds(stateStore, id, serviceId, planId)
+
+	if err != nil {
+		return "", err
+	}
+
 	service, _ := catalog.GetService(serviceId)
 	plan, _ := catalog.GetServicePlan(serviceId, planId)
 
-	status, err := helm.GetStatus(name)
+	template := getDashboardUrlTemplate(service, plan)
+
+	if len(template) == 0 {
+		return "", nil
+	}
+
+	status, nodes, values, context, err := getLookupSources(stateStore, id)
+
+	if err != nil {
+		return "", err
+	}
+
+	return renderTemplate(service, plan, "dashboard-url", template, getReleaseLookup(service, plan, nodes, status, values, nil, context))
+}
+
+func getDashboardUrlTemplate(service catalog.CatalogService, plan catalog.CatalogPlan) string {
+	if len(plan.DashboardUrl) > 0 {
+		return plan.DashboardUrl
+	}
+
+	return service.DashboardUrl
+}
+
+// getLookupSources returns everything needed to resolve lookups of a running release
+func getLookupSources(stateStore store.Store, id string) (helm.Status, [] kubectl.Node, map[string]string, map[string]interface{}, error) {
+	name := getReleaseName(stateStore, id)
+	namespace := getReleaseNamespace(stateStore, id)
+	logger := getLogger()
+
+	status, err := helm.GetStatus(name, namespace)
 
 	if err != nil {
-		exists, existsErr := helm.Exists(name)
+		exists, existsErr := helm.Exists(name, namespace)
 
 		if existsErr == nil && !exists {
 			logger.Info("asked credentials for deleted release",
 				zap.String("id", id),
 				zap.String("name", name))
 
-			return nil, err
+			return status, nil, nil, nil, err
 		}
 
 		logger.Error("failed to get release status",
@@ -204,7 +751,7 @@ func GetCredentials(catalog *catalog.Catalog, serviceId string, planId string, i
 			zap.String("name", name),
 			zap.Error(err))
 
-		return nil, err
+		return status, nil, nil, nil, err
 	}
 
 	nodes, err := kubectl.GetNodes()
@@ -215,10 +762,10 @@ func GetCredentials(catalog *catalog.Catalog, serviceId string, planId string, i
 			zap.String("name", name),
 			zap.Error(err))
 
-		return nil, err
+		return status, nil, nil, nil, err
 	}
 
-	values, err := helm.GetValues(name)
+	values, err := helm.GetValues(name, namespace)
 
 	if err != nil {
 		logger.Error("failed to get helm values",
@@ -226,271 +773,388 @@ func GetCredentials(catalog *catalog.Catalog, serviceId string, planId string, i
 			zap.String("name", name),
 			zap.Error(err))
 
-		return nil, err
+		return status, nil, nil, nil, err
 	}
 
-	credentials := getUserCredentials(service, plan, nodes, status, values)
+	var context map[string]interface{}
 
-	logger.Debug("sending release credentials",
-		zap.String("id", id),
-		zap.String("name", name))
+	if instance, err := stateStore.GetInstance(id); err == nil && instance != nil {
+		context = instance.Context
+	}
 
-	return credentials, nil
+	return status, nodes, values, context, nil
 }
 
-func getName(value string) string {
-	const prefix = "helmi"
-
-	if strings.HasPrefix(value, prefix) {
-		return value
+// getInstanceIds completes missing service and plan ids from the store
+func getInstanceIds(stateStore store.Store, id string, serviceId string, planId string) (string, string, error) {
+	if len(serviceId) > 0 && len(planId) > 0 {
+		return serviceId, planId, nil
 	}
 
-	name := strings.ToLower(value)
-	name = strings.Replace(name, "-", "", -1)
-	name = strings.Replace(name, "_", "", -1)
+	instance, err := stateStore.GetInstance(id)
 
-	return prefix + name[:14]
-}
+	if err != nil {
+		return serviceId, planId, err
+	}
 
-func getChart(service catalog.CatalogService, plan catalog.CatalogPlan) (string, error) {
-	if len(plan.Chart) > 0 {
-		return plan.Chart, nil
+	if instance == nil {
+		return serviceId, planId, ErrInstanceNotFound
 	}
 
-	if len(service.Chart) > 0 {
-		return service.Chart, nil
+	if len(serviceId) == 0 {
+		serviceId = instance.ServiceId
 	}
 
-	return "", errors.New("no helm chart specified")
+	if len(planId) == 0 {
+		planId = instance.PlanId
+	}
+
+	return serviceId, planId, nil
 }
 
-func getChartVersion(service catalog.CatalogService, plan catalog.CatalogPlan) (string, error) {
-	if len(plan.ChartVersion) > 0 {
-		return plan.ChartVersion, nil
+// finishOperation finishes a running asynchronous operation once the revision it installed failed or rolled out,
+// the ready resources of an older revision do not finish an update
+func finishOperation(operation *store.Operation, status Status) bool {
+	if !isSettled(operation, status) {
+		return false
 	}
 
-	if len(service.ChartVersion) > 0 {
-		return service.ChartVersion, nil
+	if status.IsFailed {
+		operation.Finish(errors.New("release failed"))
+	} else {
+		operation.Finish(nil)
 	}
 
-	return "", errors.New("no helm chart version specified")
+	return true
 }
 
-func getChartValues(service catalog.CatalogService, plan catalog.CatalogPlan) map[string]string {
-	values := map[string]string{}
-	templates := map[string]string{}
+func isSettled(operation *store.Operation, status Status) bool {
+	if status.Revision < operation.Revision {
+		return false
+	}
+
+	return status.IsFailed || status.IsAvailable
+}
+
+// hasOperationInProgress checks the status of a running operation without changing it
+func hasOperationInProgress(stateStore store.Store, instance *store.Instance) bool {
+	operation := instance.LastOperation()
 
-	for key, value := range service.ChartValues {
-		templates[key] = value
+	if operation == nil || operation.State != store.StateInProgress {
+		return false
 	}
 
-	for key, value := range plan.ChartValues {
-		templates[key] = value
+	if operation.Type == store.OperationDeprovision {
+		return true
 	}
 
-	usernames := map[string]string{}
-	passwords := map[string]string{}
+	status, err := GetStatus(stateStore, instance.Id)
 
-	r := regexp.MustCompile(lookupRegex)
-	groupNames := r.SubexpNames()
+	if err != nil {
+		return true
+	}
 
-	for key, template := range templates {
-		value := r.ReplaceAllStringFunc(template, func(m string) string {
-			var lookupType string
-			var lookupPath string
+	return !isSettled(operation, status)
+}
 
-			for groupKey, groupValue := range r.FindStringSubmatch(m) {
-				groupName := groupNames[groupKey]
+// compareRelease compares a provision request with a release which is missing in the store
+func compareRelease(catalog *catalog.Catalog, stateStore store.Store, serviceId string, planId string, id string, parameters map[string]interface{}) (Comparison, error) {
+	name := getReleaseName(stateStore, id)
 
-				if strings.EqualFold(groupName, lookupRegexType) {
-					lookupType = groupValue
-				}
+	service, _ := catalog.GetService(serviceId)
+	plan, _ := catalog.GetServicePlan(serviceId, planId)
 
-				if strings.EqualFold(groupName, lookupRegexPath) {
-					lookupPath = groupValue
-				}
-			}
+	// without a record only namespaces independent of the context can be found
+	namespace := getNamespace(getNamespaceSettings(service, plan), name, nil)
 
-			if strings.EqualFold(lookupType, lookupUsername) {
-				username := usernames[lookupPath]
+	exists, err := helm.Exists(name, namespace)
 
-				if len(username) == 0 {
-					username = uuid.NewV4().String()
-					username = strings.Replace(username, "-", "", -1)
-					usernames[lookupPath] = username
-				}
+	if err != nil || !exists {
+		return NotFound, err
+	}
 
-				return username
-			}
+	parameterValues, err := getParameterValues(service, plan, parameters)
 
-			if strings.EqualFold(lookupType, lookupPassword) {
-				password := passwords[lookupPath]
+	if err != nil {
+		return Conflicting, nil
+	}
 
-				if len(password) == 0 {
-					password = uuid.NewV4().String()
-					password = strings.Replace(password, "-", "", -1)
-					passwords[lookupPath] = password
-				}
+	helmValues, err := helm.GetValues(name, namespace)
 
-				return password
-			}
+	if err != nil {
+		getLogger().Error("failed to get release values",
+			zap.String("id", id),
+			zap.String("name", name),
+			zap.Error(err))
 
-			if strings.EqualFold(lookupType, lookupEnv) {
-				env, _ := os.LookupEnv(lookupPath)
-				return env
-			}
+		return NotFound, err
+	}
+
+	if !isIdenticalRelease(service, plan, name, namespace, parameterValues, helmValues) {
+		return Conflicting, nil
+	}
 
-			return ""
-		})
+	status, err := GetStatus(stateStore, id)
 
-		if len(value) > 0 {
-			values[key] = value
-		}
+	if err != nil {
+		return NotFound, err
 	}
 
-	return values
-}
+	if status.IsFailed {
+		return Identical, ErrProvisionFailed
+	}
+
+	if !status.IsAvailable {
+		return InProgress, nil
+	}
 
-func getUserCredentials(service catalog.CatalogService, plan catalog.CatalogPlan, kubernetesNodes [] kubectl.Node, helmStatus helm.Status, helmValues map[string]string) map[string]interface{} {
-	values := map[string]interface{}{}
-	templates := map[string]interface{}{}
+	return Identical, nil
+}
 
-	for key, value := range service.UserCredentials {
-		templates[key] = value
+func isIdentical(serviceId string, planId string, parameters map[string]interface{}, requestedServiceId string, requestedPlanId string, requestedParameters map[string]interface{}) bool {
+	if !strings.EqualFold(serviceId, requestedServiceId) || !strings.EqualFold(planId, requestedPlanId) {
+		return false
 	}
 
-	for key, value := range plan.UserCredentials {
-		templates[key] = value
+	if len(parameters) == 0 && len(requestedParameters) == 0 {
+		return true
 	}
 
-	r := regexp.MustCompile(lookupRegex)
-	groupNames := r.SubexpNames()
+	return reflect.DeepEqual(parameters, requestedParameters)
+}
 
-	replaceTemplate := func(template string) string {
-		var lookupType string
-		var lookupPath string
+// isIdenticalRelease checks if helm values match the chart values of a plan,
+// generated values and values of the unknown context are ignored, they are taken from the release to not issue certificates
+func isIdenticalRelease(service catalog.CatalogService, plan catalog.CatalogPlan, name string, namespace string, parameterValues map[string]string, helmValues map[string]string) bool {
+	chartValues, err := getChartValues(service, plan, name, namespace, parameterValues, helmValues, nil)
 
-		for groupKey, groupValue := range r.FindStringSubmatch(template) {
-			groupName := groupNames[groupKey]
+	if err != nil {
+		return false
+	}
 
-			if strings.EqualFold(groupName, lookupRegexType) {
-				lookupType = groupValue
-			}
+	for key, value := range chartValues {
+		template, isPlanValue := plan.ChartValues[key]
 
-			if strings.EqualFold(groupName, lookupRegexPath) {
-				lookupPath = groupValue
-			}
+		if !isPlanValue {
+			template = service.ChartValues[key]
 		}
 
-		if strings.EqualFold(lookupType, lookupRelease) {
-			if strings.EqualFold(lookupPath, "name") {
-				return helmStatus.Name
-			}
-			if strings.EqualFold(lookupPath, "namespace") {
-				return helmStatus.Namespace
-			}
-		}
+		_, isParameter := parameterValues[key]
 
-		if strings.EqualFold(lookupType, lookupUsername) {
-			username := helmValues[lookupPath]
-			return username
+		if !isParameter && isGenerated(template) {
+			continue
 		}
 
-		if strings.EqualFold(lookupType, lookupPassword) {
-			password := helmValues[lookupPath]
-			return password
+		if helmValues[key] != value {
+			return false
 		}
+	}
+
+	return true
+}
+
+// isGenerated returns true if a chart value template looks up generated usernames, passwords, tokens, keys, certificates or the context
+func isGenerated(text string) bool {
+	calls, err := template.Parse("chart-value", text)
+
+	if err != nil {
+		return false
+	}
 
-		if strings.EqualFold(lookupType, lookupValue) {
-			value := helmValues[lookupPath]
-			return value
+	for _, call := range calls {
+		switch strings.ToLower(call.Type) {
+		case lookupUsername, lookupPassword, lookupToken, lookupKey, lookupTls, lookupContext:
+			return true
 		}
+	}
 
-		if strings.EqualFold(lookupType, lookupCluster) {
-			if strings.HasPrefix(strings.ToLower(lookupPath), "port") {
-				portParts := strings.Split(lookupPath, ":")
+	return false
+}
 
-				for clusterPort, nodePort := range helmStatus.NodePorts {
-					if len(portParts) == 1 || strings.EqualFold(strconv.Itoa(clusterPort), portParts[1]) {
-						return strconv.Itoa(nodePort)
-					}
-				}
+func getInstanceOperation(instance *store.Instance, operationId string) *store.Operation {
+	if len(operationId) == 0 {
+		return instance.LastOperation()
+	}
 
-				return portParts[1]
-			}
+	return instance.GetOperation(operationId)
+}
 
-			// single host
-
-			if strings.EqualFold(lookupPath, "address") {
-				// return dns name if set as environment variable
-				if value, ok := os.LookupEnv("DOMAIN"); ok {
-					return value
-				}
-
-				for _, node := range kubernetesNodes {
-					if len(node.ExternalIP) > 0 {
-						return node.ExternalIP
-					}
-				}
-
-				for _, node := range kubernetesNodes {
-					if len(node.InternalIP) > 0 {
-						return node.InternalIP
-					}
-				}
-			}
+// getReleaseOperation describes the state of a release without recorded operations
+func getReleaseOperation(stateStore store.Store, id string) (*store.Operation, error) {
+	status, err := GetStatus(stateStore, id)
 
-			if strings.EqualFold(lookupPath, "hostname") {
-				for _, node := range kubernetesNodes {
-					if len(node.Hostname) > 0 {
-						return node.Hostname
-					}
-				}
-			}
+	if err != nil {
+		exists, existsErr := helm.Exists(getReleaseName(stateStore, id), getReleaseNamespace(stateStore, id))
+
+		if existsErr == nil && !exists {
+			return nil, ErrInstanceNotFound
 		}
 
+		return nil, err
+	}
+
+	operation := &store.Operation{
+		State:       store.StateInProgress,
+		Description: getStatusDescription(status),
+	}
+
+	if status.IsFailed {
+		operation.State = store.StateFailed
+	} else if status.IsAvailable {
+		operation.State = store.StateSucceeded
+	}
+
+	return operation, nil
+}
+
+func getStatusDescription(status Status) string {
+	return fmt.Sprintf("%d/%d pods available", status.AvailableNodes, status.DesiredNodes)
+}
+
+func saveInstance(stateStore store.Store, instance *store.Instance) error {
+	err := stateStore.SaveInstance(instance)
+
+	if err != nil {
+		getLogger().Error("failed to save instance to store",
+			zap.String("id", instance.Id),
+			zap.String("name", instance.ReleaseName),
+			zap.Error(err))
+	}
+
+	return err
+}
+
+// getReleaseNamespace returns the namespace recorded for an instance, empty for releases unknown to the store
+func getReleaseNamespace(stateStore store.Store, id string) string {
+	instance, err := stateStore.GetInstance(id)
+
+	if err != nil || instance == nil {
 		return ""
 	}
 
-	for key, templateInterface := range templates {
-		// string
-		templateString, ok := reflect.ValueOf(templateInterface).Interface().(string)
+	return instance.Namespace
+}
 
-		if ok {
-			value := r.ReplaceAllStringFunc(templateString, replaceTemplate)
+func deleteInstance(stateStore store.Store, id string) error {
+	err := stateStore.DeleteInstance(id)
 
-			if len(value) > 0 {
-				values[key] = value
-			}
+	if err != nil {
+		getLogger().Error("failed to delete instance from store",
+			zap.String("id", id),
+			zap.Error(err))
+	}
 
-			continue
+	return err
+}
+
+// getName returns the legacy release name of an instance id
+func getName(value string) string {
+	if strings.HasPrefix(value, namePrefix) {
+		return value
+	}
+
+	return namePrefix + getShortId(value)
+}
+
+func getChart(service catalog.CatalogService, plan catalog.CatalogPlan) (string, error) {
+	if len(plan.Chart) > 0 {
+		return plan.Chart, nil
+	}
+
+	if len(service.Chart) > 0 {
+		return service.Chart, nil
+	}
+
+	return "", errors.New("no helm chart specified")
+}
+
+func getChartVersion(service catalog.CatalogService, plan catalog.CatalogPlan) (string, error) {
+	if len(plan.ChartVersion) > 0 {
+		return plan.ChartVersion, nil
+	}
+
+	if len(service.ChartVersion) > 0 {
+		return service.ChartVersion, nil
+	}
+
+	return "", errors.New("no helm chart version specified")
+}
+
+func validateParameters(service catalog.CatalogService, plan catalog.CatalogPlan, parameterSchema map[string]interface{}, parameters map[string]interface{}) error {
+	violations := getSchemaViolations(parameterSchema, parameters)
+
+	if _, err := getParameterValues(service, plan, parameters); err != nil {
+		if parameterErr, ok := err.(ParameterError); ok {
+			violations = append(violations, parameterErr.Violations...)
+		} else {
+			return err
 		}
+	}
 
-		// string array
-		templateStringArray, ok := reflect.ValueOf(templateInterface).Interface().([]interface{})
+	if len(violations) > 0 {
+		return ParameterError{Violations: violations}
+	}
 
-		if ok {
-			valueArray := []string{}
+	return nil
+}
 
-			for _, templateValue := range templateStringArray {
-				templateString, ok := reflect.ValueOf(templateValue).Interface().(string)
+func getSchemaViolations(parameterSchema map[string]interface{}, parameters map[string]interface{}) []string {
+	if parameterSchema == nil {
+		return nil
+	}
 
-				if ok {
-					value := r.ReplaceAllStringFunc(templateString, replaceTemplate)
+	if parameters == nil {
+		parameters = map[string]interface{}{}
+	}
 
-					if len(value) > 0 {
-						valueArray = append(valueArray, value)
-					}
-				}
-			}
+	return schema.Validate(parameterSchema, parameters)
+}
 
-			if len(valueArray) > 0 {
-				values[key] = valueArray
-			}
+func getParameterValues(service catalog.CatalogService, plan catalog.CatalogPlan, parameters map[string]interface{}) (map[string]string, error) {
+	values := map[string]string{}
+	keys := map[string]string{}
 
+	for name, key := range service.UserParameters {
+		keys[name] = key
+	}
+
+	for name, key := range plan.UserParameters {
+		keys[name] = key
+	}
+
+	var names []string
+
+	for name := range parameters {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var problems []string
+
+	for _, name := range names {
+		key, ok := keys[name]
+
+		if !ok || len(key) == 0 {
+			problems = append(problems, "parameter '"+name+"' is not supported")
 			continue
 		}
+
+		switch value := parameters[name].(type) {
+		case string:
+			values[key] = value
+		case bool:
+			values[key] = strconv.FormatBool(value)
+		case float64:
+			values[key] = strconv.FormatFloat(value, 'f', -1, 64)
+		default:
+			problems = append(problems, "parameter '"+name+"' must be a string, number or boolean")
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, ParameterError{Violations: problems}
 	}
 
-	return values
+	return values, nil
 }
@@ -0,0 +1,223 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Validate checks a decoded JSON value against a JSON schema (draft-04 subset)
+// and returns one message per violation
+func Validate(schema map[string]interface{}, value interface{}) []string {
+	var violations []string
+
+	validate(schema, value, "", &violations)
+
+	return violations
+}
+
+func validate(schema map[string]interface{}, value interface{}, path string, violations *[]string) {
+	if schema == nil {
+		return
+	}
+
+	report := func(format string, args ...interface{}) {
+		name := path
+
+		if len(name) == 0 {
+			name = "parameters"
+		}
+
+		*violations = append(*violations, name+": "+fmt.Sprintf(format, args...))
+	}
+
+	if types := getTypes(schema["type"]); len(types) > 0 {
+		valueType := getType(value)
+		matches := false
+
+		for _, t := range types {
+			if t == valueType || (t == "number" && valueType == "integer") {
+				matches = true
+			}
+		}
+
+		if !matches {
+			report("must be of type %s", strings.Join(types, " or "))
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		matches := false
+
+		for _, e := range enum {
+			if reflect.DeepEqual(e, value) {
+				matches = true
+			}
+		}
+
+		if !matches {
+			report("must be one of %v", enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		if min, ok := getNumber(schema["minLength"]); ok && float64(len([]rune(v))) < min {
+			report("must be at least %v characters long", min)
+		}
+
+		if max, ok := getNumber(schema["maxLength"]); ok && float64(len([]rune(v))) > max {
+			report("must be at most %v characters long", max)
+		}
+
+		if pattern, ok := schema["pattern"].(string); ok {
+			r, err := regexp.Compile(pattern)
+
+			if err != nil {
+				report("invalid pattern %s in schema", pattern)
+			} else if !r.MatchString(v) {
+				report("must match pattern %s", pattern)
+			}
+		}
+
+	case float64:
+		exclusiveMin, _ := schema["exclusiveMinimum"].(bool)
+		exclusiveMax, _ := schema["exclusiveMaximum"].(bool)
+
+		if min, ok := getNumber(schema["minimum"]); ok {
+			if exclusiveMin && v <= min {
+				report("must be greater than %v", min)
+			} else if v < min {
+				report("must be greater than or equal to %v", min)
+			}
+		}
+
+		if max, ok := getNumber(schema["maximum"]); ok {
+			if exclusiveMax && v >= max {
+				report("must be less than %v", max)
+			} else if v > max {
+				report("must be less than or equal to %v", max)
+			}
+		}
+
+		if multiple, ok := getNumber(schema["multipleOf"]); ok && multiple > 0 && math.Mod(v, multiple) != 0 {
+			report("must be a multiple of %v", multiple)
+		}
+
+	case []interface{}:
+		if min, ok := getNumber(schema["minItems"]); ok && float64(len(v)) < min {
+			report("must contain at least %v items", min)
+		}
+
+		if max, ok := getNumber(schema["maxItems"]); ok && float64(len(v)) > max {
+			report("must contain at most %v items", max)
+		}
+
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validate(items, item, fmt.Sprintf("%s[%d]", path, i), violations)
+			}
+		}
+
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				name, _ := r.(string)
+
+				if _, exists := v[name]; !exists {
+					*violations = append(*violations, join(path, name)+": is required")
+				}
+			}
+		}
+
+		var names []string
+
+		for name := range v {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				validate(property, v[name], join(path, name), violations)
+				continue
+			}
+
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					*violations = append(*violations, join(path, name)+": is not allowed")
+				}
+			case map[string]interface{}:
+				validate(additional, v[name], join(path, name), violations)
+			}
+		}
+	}
+}
+
+func getTypes(value interface{}) []string {
+	switch t := value.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				types = append(types, s)
+			}
+		}
+
+		return types
+	}
+
+	return nil
+}
+
+func getType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+
+	return ""
+}
+
+func getNumber(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+
+	return 0, false
+}
+
+func join(path string, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+
+	return path + "." + name
+}
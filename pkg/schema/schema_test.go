@@ -0,0 +1,73 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"additionalProperties": false,
+	"required": [ "size" ],
+	"properties": {
+		"size": { "type": "string", "pattern": "^[0-9]+Gi$" },
+		"replicas": { "type": "integer", "minimum": 1, "maximum": 5 },
+		"mode": { "type": "string", "enum": [ "standalone", "cluster" ] },
+		"tags": { "type": "array", "maxItems": 2, "items": { "type": "string" } }
+	}
+}`
+
+func red(msg string) string {
+	return "\033[31m" + msg + "\033[39m\n\n"
+}
+
+func parse(t *testing.T, input string) map[string]interface{} {
+	value := map[string]interface{}{}
+
+	if err := json.Unmarshal([]byte(input), &value); err != nil {
+		t.Fatal(err)
+	}
+
+	return value
+}
+
+func Test_ValidateValid(t *testing.T) {
+	schema := parse(t, testSchema)
+	value := parse(t, `{ "size": "8Gi", "replicas": 3, "mode": "cluster", "tags": [ "a" ] }`)
+
+	if violations := Validate(schema, value); len(violations) != 0 {
+		t.Error(red("valid parameters rejected"), violations)
+	}
+}
+
+func Test_ValidateInvalid(t *testing.T) {
+	schema := parse(t, testSchema)
+	value := parse(t, `{ "replicas": 1.5, "mode": "other", "tags": [ "a", 1, "c" ], "foo": true }`)
+
+	violations := Validate(schema, value)
+
+	expected := []string{
+		"size: is required",
+		"foo: is not allowed",
+		"mode: must be one of [standalone cluster]",
+		"replicas: must be of type integer",
+		"tags: must contain at most 2 items",
+		"tags[1]: must be of type string",
+	}
+
+	if len(violations) != len(expected) {
+		t.Fatal(red("wrong number of violations"), violations)
+	}
+
+	for i := range expected {
+		if violations[i] != expected[i] {
+			t.Error(red("unexpected violation " + violations[i]))
+		}
+	}
+}
+
+func Test_ValidateNilSchema(t *testing.T) {
+	if violations := Validate(nil, map[string]interface{}{"foo": "bar"}); len(violations) != 0 {
+		t.Error(red("nil schema must accept everything"))
+	}
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore keeps all records in a single json file
+type FileStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+type fileData struct {
+	Instances map[string]*Instance `json:"instances"`
+	Bindings  map[string]*Binding  `json:"bindings"`
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{path: path}, nil
+}
+
+func (s *FileStore) GetInstance(id string) (*Instance, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := s.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data.Instances[id], nil
+}
+
+func (s *FileStore) SaveInstance(instance *Instance) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := s.read()
+
+	if err != nil {
+		return err
+	}
+
+	data.Instances[instance.Id] = instance
+
+	return s.write(data)
+}
+
+func (s *FileStore) DeleteInstance(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := s.read()
+
+	if err != nil {
+		return err
+	}
+
+	delete(data.Instances, id)
+
+	for key, binding := range data.Bindings {
+		if binding.InstanceId == id {
+			delete(data.Bindings, key)
+		}
+	}
+
+	return s.write(data)
+}
+
+func (s *FileStore) GetBinding(instanceId string, bindingId string) (*Binding, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := s.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data.Bindings[getBindingKey(instanceId, bindingId)], nil
+}
+
+func (s *FileStore) SaveBinding(binding *Binding) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := s.read()
+
+	if err != nil {
+		return err
+	}
+
+	data.Bindings[getBindingKey(binding.InstanceId, binding.Id)] = binding
+
+	return s.write(data)
+}
+
+func (s *FileStore) DeleteBinding(instanceId string, bindingId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := s.read()
+
+	if err != nil {
+		return err
+	}
+
+	delete(data.Bindings, getBindingKey(instanceId, bindingId))
+
+	return s.write(data)
+}
+
+func (s *FileStore) read() (*fileData, error) {
+	data := &fileData{}
+
+	input, err := ioutil.ReadFile(s.path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(input) > 0 {
+		err = json.Unmarshal(input, data)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if data.Instances == nil {
+		data.Instances = map[string]*Instance{}
+	}
+
+	if data.Bindings == nil {
+		data.Bindings = map[string]*Binding{}
+	}
+
+	return data, nil
+}
+
+// write replaces the file atomically so a crash never leaves a partial file
+func (s *FileStore) write(data *fileData) error {
+	output, err := json.MarshalIndent(data, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(output)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), s.path)
+}
+
+func getBindingKey(instanceId string, bindingId string) string {
+	return instanceId + "/" + bindingId
+}
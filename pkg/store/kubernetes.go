@@ -0,0 +1,181 @@
+package store
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/monostream/helmi/pkg/kubectl"
+	"strings"
+)
+
+const kindSecret = "secret"
+const kindConfigMap = "configmap"
+
+const recordKey = "record"
+
+// KubernetesStore keeps every record in its own secret or config map, bindings hold credentials
+// and are always kept in secrets
+type KubernetesStore struct {
+	namespace string
+	kind      string
+}
+
+func NewKubernetesStore(namespace string, kind string) (*KubernetesStore, error) {
+	kind = strings.ToLower(kind)
+
+	if len(kind) == 0 {
+		kind = kindSecret
+	}
+
+	if kind != kindSecret && kind != kindConfigMap {
+		return nil, errors.New("unsupported kubernetes store kind " + kind)
+	}
+
+	return &KubernetesStore{namespace: namespace, kind: kind}, nil
+}
+
+func (s *KubernetesStore) GetInstance(id string) (*Instance, error) {
+	var instance *Instance
+
+	found, err := s.get(s.kind, getInstanceName(id), &instance)
+
+	if !found || err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+func (s *KubernetesStore) SaveInstance(instance *Instance) error {
+	return s.save(s.kind, getInstanceName(instance.Id), "instance", nil, instance)
+}
+
+func (s *KubernetesStore) DeleteInstance(id string) error {
+	output, err := kubectl.List(s.namespace, kindSecret, "helmi-store=binding,helmi-instance="+getHash(id))
+
+	if err != nil {
+		return err
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+
+	if err := json.Unmarshal(output, &list); err != nil {
+		return err
+	}
+
+	for _, item := range list.Items {
+		if err := kubectl.Delete(s.namespace, kindSecret, item.Metadata.Name); err != nil {
+			return err
+		}
+	}
+
+	return kubectl.Delete(s.namespace, s.kind, getInstanceName(id))
+}
+
+func (s *KubernetesStore) GetBinding(instanceId string, bindingId string) (*Binding, error) {
+	var binding *Binding
+
+	found, err := s.get(kindSecret, getBindingName(instanceId, bindingId), &binding)
+
+	if !found || err != nil {
+		return nil, err
+	}
+
+	return binding, nil
+}
+
+func (s *KubernetesStore) SaveBinding(binding *Binding) error {
+	// the label finds the bindings of an instance when it is deleted
+	labels := map[string]string{"helmi-instance": getHash(binding.InstanceId)}
+
+	return s.save(kindSecret, getBindingName(binding.InstanceId, binding.Id), "binding", labels, binding)
+}
+
+func (s *KubernetesStore) DeleteBinding(instanceId string, bindingId string) error {
+	return kubectl.Delete(s.namespace, kindSecret, getBindingName(instanceId, bindingId))
+}
+
+func (s *KubernetesStore) get(kind string, name string, record interface{}) (bool, error) {
+	var data map[string]string
+	var err error
+
+	if kind == kindSecret {
+		data, err = kubectl.GetSecret(s.namespace, name)
+	} else {
+		data, err = kubectl.GetConfigMap(s.namespace, name)
+	}
+
+	if data == nil || err != nil {
+		return false, err
+	}
+
+	value, exists := data[recordKey]
+
+	if !exists {
+		return false, nil
+	}
+
+	return true, json.Unmarshal([]byte(value), record)
+}
+
+func (s *KubernetesStore) save(kind string, name string, recordType string, labels map[string]string, record interface{}) error {
+	value, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	recordLabels := map[string]string{
+		"heritage":    "helmi",
+		"helmi-store": recordType,
+	}
+
+	for key, value := range labels {
+		recordLabels[key] = value
+	}
+
+	resource := map[string]interface{}{
+		"apiVersion": "v1",
+		"metadata": map[string]interface{}{
+			"name":   name,
+			"labels": recordLabels,
+		},
+	}
+
+	if kind == kindSecret {
+		resource["kind"] = "Secret"
+		resource["type"] = "Opaque"
+		resource["stringData"] = map[string]string{recordKey: string(value)}
+	} else {
+		resource["kind"] = "ConfigMap"
+		resource["data"] = map[string]string{recordKey: string(value)}
+	}
+
+	manifest, err := json.Marshal(resource)
+
+	if err != nil {
+		return err
+	}
+
+	return kubectl.Apply(s.namespace, manifest)
+}
+
+func getInstanceName(id string) string {
+	return "helmi-instance-" + getHash(id)
+}
+
+func getBindingName(instanceId string, bindingId string) string {
+	return "helmi-binding-" + getHash(getBindingKey(instanceId, bindingId))
+}
+
+func getHash(value string) string {
+	hash := sha1.Sum([]byte(value))
+	return hex.EncodeToString(hash[:])[:20]
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"errors"
+	"github.com/satori/go.uuid"
+	"os"
+	"strings"
+	"time"
+)
+
+const OperationProvision = "provision"
+const OperationUpdate = "update"
+const OperationDeprovision = "deprovision"
+const OperationBind = "bind"
+const OperationUnbind = "unbind"
+
+// number of operations kept per instance or binding
+const maxOperations = 20
+
+const StateInProgress = "in progress"
+const StateSucceeded = "succeeded"
+const StateFailed = "failed"
+
+type Operation struct {
+	Id          string `json:"id"`
+	Type        string `json:"type"`
+	State       string `json:"state"`
+	Description string `json:"description,omitempty"`
+	// revision of the release installed by the operation
+	Revision int       `json:"revision,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+type Instance struct {
+	Id          string `json:"id"`
+	ServiceId   string `json:"service_id"`
+	PlanId      string `json:"plan_id"`
+	ReleaseName string `json:"release_name"`
+
+	Namespace        string `json:"namespace,omitempty"`
+	NamespaceCreated bool   `json:"namespace_created,omitempty"`
+
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Context    map[string]interface{} `json:"context,omitempty"`
+
+	Operations []Operation `json:"operations,omitempty"`
+}
+
+type Binding struct {
+	Id         string `json:"id"`
+	InstanceId string `json:"instance_id"`
+	ServiceId  string `json:"service_id"`
+	PlanId     string `json:"plan_id"`
+
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Values      map[string]string      `json:"values,omitempty"`
+	Credentials map[string]interface{} `json:"credentials,omitempty"`
+
+	Operations []Operation `json:"operations,omitempty"`
+}
+
+// Store keeps track of instances and bindings created by the broker.
+// Getters return nil without an error if nothing is stored for the id.
+type Store interface {
+	GetInstance(id string) (*Instance, error)
+	SaveInstance(instance *Instance) error
+	// DeleteInstance removes the bindings of the instance as well
+	DeleteInstance(id string) error
+
+	GetBinding(instanceId string, bindingId string) (*Binding, error)
+	SaveBinding(binding *Binding) error
+	DeleteBinding(instanceId string, bindingId string) error
+}
+
+// NewStore creates the store configured by the STORE environment variable
+func NewStore() (Store, error) {
+	storeType, _ := os.LookupEnv("STORE")
+
+	switch strings.ToLower(storeType) {
+	case "", "kubernetes":
+		namespace, _ := os.LookupEnv("STORE_NAMESPACE")
+		kind, _ := os.LookupEnv("STORE_KIND")
+
+		return NewKubernetesStore(namespace, kind)
+	case "file":
+		path, exists := os.LookupEnv("STORE_PATH")
+
+		if !exists {
+			path = "helmi.db"
+		}
+
+		return NewFileStore(path)
+	}
+
+	return nil, errors.New("unknown store " + storeType)
+}
+
+// LastOperation returns the most recent operation or nil
+func (i *Instance) LastOperation() *Operation {
+	if len(i.Operations) == 0 {
+		return nil
+	}
+
+	return &i.Operations[len(i.Operations)-1]
+}
+
+// StartOperation appends a new operation in progress
+func (i *Instance) StartOperation(operationType string) *Operation {
+	i.Operations = append(i.Operations, Operation{
+		Id:      uuid.NewV4().String(),
+		Type:    operationType,
+		State:   StateInProgress,
+		Started: time.Now(),
+	})
+
+	if len(i.Operations) > maxOperations {
+		i.Operations = i.Operations[len(i.Operations)-maxOperations:]
+	}
+
+	return i.LastOperation()
+}
+
+func (b *Binding) LastOperation() *Operation {
+	if len(b.Operations) == 0 {
+		return nil
+	}
+
+	return &b.Operations[len(b.Operations)-1]
+}
+
+func (b *Binding) StartOperation(operationType string) *Operation {
+	b.Operations = append(b.Operations, Operation{
+		Id:      uuid.NewV4().String(),
+		Type:    operationType,
+		State:   StateInProgress,
+		Started: time.Now(),
+	})
+
+	if len(b.Operations) > maxOperations {
+		b.Operations = b.Operations[len(b.Operations)-maxOperations:]
+	}
+
+	return b.LastOperation()
+}
+
+// GetOperation returns the operation with the given id or nil
+func (i *Instance) GetOperation(id string) *Operation {
+	return findOperation(i.Operations, id)
+}
+
+func (b *Binding) GetOperation(id string) *Operation {
+	return findOperation(b.Operations, id)
+}
+
+func findOperation(operations []Operation, id string) *Operation {
+	for index := range operations {
+		if operations[index].Id == id {
+			return &operations[index]
+		}
+	}
+
+	return nil
+}
+
+// Finish marks the operation as succeeded or failed depending on err
+func (o *Operation) Finish(err error) {
+	o.Finished = time.Now()
+
+	if err != nil {
+		o.State = StateFailed
+		o.Description = err.Error()
+		return
+	}
+
+	o.State = StateSucceeded
+}
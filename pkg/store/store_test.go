@@ -0,0 +1,158 @@
+package store
+
+import (
+	"errors"
+	"github.com/monostream/helmi/pkg/command"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func red(msg string) string {
+	return "\033[31m" + msg + "\033[39m\n\n"
+}
+
+func Test_FileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helmi")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	s, _ := NewFileStore(filepath.Join(dir, "helmi.db"))
+
+	instance, err := s.GetInstance("1234")
+
+	if instance != nil || err != nil {
+		t.Error(red("missing instance returned"))
+	}
+
+	err = s.SaveInstance(&Instance{
+		Id:        "1234",
+		ServiceId: "service",
+		PlanId:    "plan",
+
+		Parameters: map[string]interface{}{"size": "8Gi"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err = s.GetInstance("1234")
+
+	if err != nil || instance == nil || instance.PlanId != "plan" || instance.Parameters["size"] != "8Gi" {
+		t.Error(red("stored instance is wrong"))
+	}
+
+	err = s.SaveBinding(&Binding{
+		Id:         "5678",
+		InstanceId: "1234",
+
+		Values: map[string]string{"username": "user"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binding, err := s.GetBinding("1234", "5678")
+
+	if err != nil || binding == nil || binding.Values["username"] != "user" {
+		t.Error(red("stored binding is wrong"))
+	}
+
+	s.SaveBinding(&Binding{Id: "9012", InstanceId: "1234"})
+	s.SaveBinding(&Binding{Id: "5678", InstanceId: "other"})
+
+	s.DeleteBinding("1234", "5678")
+	s.DeleteInstance("1234")
+
+	binding, _ = s.GetBinding("1234", "5678")
+	instance, _ = s.GetInstance("1234")
+
+	if binding != nil || instance != nil {
+		t.Error(red("records not deleted"))
+	}
+
+	if binding, _ = s.GetBinding("1234", "9012"); binding != nil {
+		t.Error(red("binding of deleted instance not deleted"))
+	}
+	if binding, _ = s.GetBinding("other", "5678"); binding == nil {
+		t.Error(red("binding of other instance deleted"))
+	}
+}
+
+func Test_KubernetesStoreBindingSecret(t *testing.T) {
+	fake := &command.Fake{Results: []command.Result{{}, {}}}
+
+	defer command.SetExecutor(command.SetExecutor(fake))
+
+	s, _ := NewKubernetesStore("helmi", kindConfigMap)
+
+	s.SaveInstance(&Instance{Id: "1234"})
+	s.SaveBinding(&Binding{Id: "5678", InstanceId: "1234", Credentials: map[string]interface{}{"password": "s3cret"}})
+
+	if !strings.Contains(string(fake.Inputs[0]), `"kind":"ConfigMap"`) {
+		t.Error(red("instance not kept in a config map"))
+	}
+	if !strings.Contains(string(fake.Inputs[1]), `"kind":"Secret"`) || !strings.Contains(string(fake.Inputs[1]), `"helmi-instance":"`+getHash("1234")+`"`) {
+		t.Error(red("binding credentials not kept in a secret: " + string(fake.Inputs[1])))
+	}
+}
+
+func Test_KubernetesStoreDeleteInstance(t *testing.T) {
+	fake := &command.Fake{Results: []command.Result{
+		{Stdout: `{"items": [{"metadata": {"name": "helmi-binding-a"}}, {"metadata": {"name": "helmi-binding-b"}}]}`},
+		{}, {}, {},
+	}}
+
+	defer command.SetExecutor(command.SetExecutor(fake))
+
+	s, _ := NewKubernetesStore("helmi", kindSecret)
+
+	if err := s.DeleteInstance("1234"); err != nil {
+		t.Fatal(red("failed to delete instance: " + err.Error()))
+	}
+
+	expected := []string{
+		"kubectl get secret --output json --selector helmi-store=binding,helmi-instance=" + getHash("1234") + " --namespace helmi",
+		"kubectl delete secret helmi-binding-a --ignore-not-found --namespace helmi",
+		"kubectl delete secret helmi-binding-b --ignore-not-found --namespace helmi",
+		"kubectl delete secret " + getInstanceName("1234") + " --ignore-not-found --namespace helmi",
+	}
+
+	if strings.Join(fake.Commands, "\n") != strings.Join(expected, "\n") {
+		t.Error(red("bindings of instance not deleted: " + strings.Join(fake.Commands, ", ")))
+	}
+}
+
+func Test_Operations(t *testing.T) {
+	instance := Instance{}
+
+	for i := 0; i < maxOperations+5; i++ {
+		instance.StartOperation(OperationUpdate)
+	}
+
+	if len(instance.Operations) != maxOperations {
+		t.Error(red("operation history not limited"))
+	}
+
+	operation := instance.StartOperation(OperationProvision)
+	operation.Finish(errors.New("boom"))
+
+	if last := instance.LastOperation(); last.State != StateFailed || last.Description != "boom" {
+		t.Error(red("last operation is wrong"))
+	}
+
+	if len(operation.Id) == 0 || instance.GetOperation(operation.Id) != operation {
+		t.Error(red("operation not found by id"))
+	}
+	if instance.GetOperation("unknown") != nil {
+		t.Error(red("unknown operation found"))
+	}
+}
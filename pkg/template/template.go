@@ -0,0 +1,502 @@
+package template
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Lookup resolves lookup('type', 'path') of a template, options are given like lookup('type', 'path', length=24)
+type Lookup func(lookupType string, path string, options map[string]string) (string, error)
+
+// Call is a lookup of a template whose type, path and options are constant
+type Call struct {
+	Type    string
+	Path    string
+	Options map[string]string
+}
+
+// Render executes a template of the catalog. Besides the functions of text/template, templates may use
+// lookup, default, b64enc, b64dec, upper, lower, list, join, quote and required. Functions can be called
+// like lookup('type', 'path') as well, which is translated into the syntax of text/template.
+func Render(name string, text string, lookup Lookup) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	t, err := newTemplate(name, text, lookup)
+
+	if err != nil {
+		return "", err
+	}
+
+	var output bytes.Buffer
+
+	if err := t.Execute(&output, nil); err != nil {
+		return "", err
+	}
+
+	return output.String(), nil
+}
+
+// Parse checks the syntax of a template and returns its lookups with constant arguments
+func Parse(name string, text string) ([]Call, error) {
+	if !strings.Contains(text, "{{") {
+		return nil, nil
+	}
+
+	t, err := newTemplate(name, text, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []Call
+
+	err = walk(t.Tree.Root, func(command *parse.CommandNode) error {
+		identifier, ok := command.Args[0].(*parse.IdentifierNode)
+
+		if !ok || identifier.Ident != "lookup" {
+			return nil
+		}
+
+		if len(command.Args) < 3 {
+			return errors.New("lookup requires a type and a path")
+		}
+
+		lookupType, isConstantType := command.Args[1].(*parse.StringNode)
+		lookupPath, isConstantPath := command.Args[2].(*parse.StringNode)
+
+		var options []string
+
+		for _, argument := range command.Args[3:] {
+			option, isConstant := argument.(*parse.StringNode)
+
+			if !isConstant {
+				return nil
+			}
+
+			options = append(options, option.Text)
+		}
+
+		parsedOptions, err := parseOptions(options)
+
+		if err != nil {
+			return err
+		}
+
+		// lookups of computed values can only be checked when they are rendered
+		if isConstantType && isConstantPath {
+			calls = append(calls, Call{Type: lookupType.Text, Path: lookupPath.Text, Options: parsedOptions})
+		}
+
+		return nil
+	})
+
+	return calls, err
+}
+
+func newTemplate(name string, text string, lookup Lookup) (*template.Template, error) {
+	translated, err := translate(text)
+
+	if err != nil {
+		return nil, errors.New("template: " + name + ": " + err.Error())
+	}
+
+	return template.New(name).Funcs(getFunctions(lookup)).Option("missingkey=error").Parse(translated)
+}
+
+// walk calls the function for every command of the tree
+func walk(node parse.Node, f func(*parse.CommandNode) error) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+
+		for _, child := range n.Nodes {
+			if err := walk(child, f); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return walk(n.Pipe, f)
+	case *parse.IfNode:
+		return walkBranch(&n.BranchNode, f)
+	case *parse.RangeNode:
+		return walkBranch(&n.BranchNode, f)
+	case *parse.WithNode:
+		return walkBranch(&n.BranchNode, f)
+	case *parse.TemplateNode:
+		return walk(n.Pipe, f)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+
+		for _, command := range n.Cmds {
+			if err := f(command); err != nil {
+				return err
+			}
+
+			for _, argument := range command.Args {
+				if err := walk(argument, f); err != nil {
+					return err
+				}
+			}
+		}
+	case *parse.ChainNode:
+		return walk(n.Node, f)
+	}
+
+	return nil
+}
+
+func walkBranch(branch *parse.BranchNode, f func(*parse.CommandNode) error) error {
+	if err := walk(branch.Pipe, f); err != nil {
+		return err
+	}
+
+	if err := walk(branch.List, f); err != nil {
+		return err
+	}
+
+	return walk(branch.ElseList, f)
+}
+
+func getFunctions(lookup Lookup) template.FuncMap {
+	if lookup == nil {
+		lookup = func(lookupType string, path string, options map[string]string) (string, error) {
+			return "", nil
+		}
+	}
+
+	return template.FuncMap{
+		"lookup": func(lookupType string, path string, options ...string) (string, error) {
+			parsedOptions, err := parseOptions(options)
+
+			if err != nil {
+				return "", err
+			}
+
+			return lookup(lookupType, path, parsedOptions)
+		},
+		"default": func(fallback interface{}, values ...interface{}) interface{} {
+			if len(values) == 0 || isEmpty(values[0]) {
+				return fallback
+			}
+
+			return values[0]
+		},
+		"required": func(message string, value interface{}) (interface{}, error) {
+			if isEmpty(value) {
+				return nil, errors.New(message)
+			}
+
+			return value, nil
+		},
+		"b64enc": func(value string) string {
+			return base64.StdEncoding.EncodeToString([]byte(value))
+		},
+		"b64dec": func(value string) (string, error) {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			return string(decoded), err
+		},
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"list": func(values ...interface{}) []interface{} {
+			return values
+		},
+		"join": func(separator string, values interface{}) string {
+			var items []string
+
+			v := reflect.ValueOf(values)
+
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				return fmt.Sprint(values)
+			}
+
+			for i := 0; i < v.Len(); i++ {
+				items = append(items, fmt.Sprint(v.Index(i).Interface()))
+			}
+
+			return strings.Join(items, separator)
+		},
+		"quote": func(values ...interface{}) string {
+			var quoted []string
+
+			for _, value := range values {
+				quoted = append(quoted, strconv.Quote(fmt.Sprint(value)))
+			}
+
+			return strings.Join(quoted, " ")
+		},
+	}
+}
+
+// parseOptions reads the options of a lookup, which are passed as "name=value"
+func parseOptions(options []string) (map[string]string, error) {
+	parsed := map[string]string{}
+
+	for _, option := range options {
+		separator := strings.Index(option, "=")
+
+		if separator < 1 {
+			return nil, errors.New("lookup option " + strconv.Quote(option) + " is not of the form name=value")
+		}
+
+		parsed[option[:separator]] = option[separator+1:]
+	}
+
+	return parsed, nil
+}
+
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+// translate converts calls like lookup('type', 'path', length=24) within the actions of a template into
+// (lookup "type" "path" "length=24"), everything else is kept so templates can use the syntax of text/template
+func translate(text string) (string, error) {
+	var output bytes.Buffer
+
+	for {
+		start := strings.Index(text, "{{")
+
+		if start < 0 {
+			output.WriteString(text)
+			return output.String(), nil
+		}
+
+		output.WriteString(text[:start+2])
+		text = text[start+2:]
+
+		action, rest, err := translateAction(text)
+
+		if err != nil {
+			return "", err
+		}
+
+		output.WriteString(action)
+		text = rest
+	}
+}
+
+// translateAction translates the action up to and including its closing braces
+func translateAction(text string) (string, string, error) {
+	var output bytes.Buffer
+
+	// true for parentheses of translated calls, whose arguments are separated by commas
+	var calls []bool
+
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch {
+		case strings.HasPrefix(text[i:], "}}"):
+			if len(calls) > 0 {
+				return "", "", errors.New("unclosed parenthesis")
+			}
+
+			output.WriteString("}}")
+			return output.String(), text[i+2:], nil
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i:], "*/")
+
+			if end < 0 {
+				return "", "", errors.New("unclosed comment")
+			}
+
+			output.WriteString(text[i : i+end+2])
+			i += end + 2
+		case c == '\'':
+			end := i + 1
+
+			for end < len(text) && text[end] != '\'' {
+				if text[end] == '\\' {
+					end++
+				}
+
+				end++
+			}
+
+			if end >= len(text) {
+				return "", "", errors.New("unterminated quoted string")
+			}
+
+			value := strings.Replace(text[i+1:end], `\'`, `'`, -1)
+
+			output.WriteString(strconv.Quote(value))
+			i = end + 1
+		case c == '"' || c == '`':
+			end := i + 1
+
+			for end < len(text) && text[end] != c {
+				if c == '"' && text[end] == '\\' {
+					end++
+				}
+
+				end++
+			}
+
+			if end >= len(text) {
+				return "", "", errors.New("unterminated quoted string")
+			}
+
+			output.WriteString(text[i : end+1])
+			i = end + 1
+		case isIdentifierStart(c) && (i == 0 || !isIdentifierPart(text[i-1]) && text[i-1] != '.' && text[i-1] != '$'):
+			end := i
+
+			for end < len(text) && isIdentifierPart(text[end]) {
+				end++
+			}
+
+			identifier := text[i:end]
+			next := end
+
+			// options of calls like length=24 or charset=alnum+symbols are passed as "name=value"
+			if len(calls) > 0 && calls[len(calls)-1] && isOption(text[end:]) {
+				value, valueEnd, err := readOptionValue(text, strings.Index(text[end:], "=")+end+1)
+
+				if err != nil {
+					return "", "", err
+				}
+
+				output.WriteString(strconv.Quote(identifier + "=" + value))
+				i = valueEnd
+				continue
+			}
+
+			// the lookup function was called with spaces before its arguments by earlier catalogs
+			if identifier == "lookup" {
+				for next < len(text) && text[next] == ' ' {
+					next++
+				}
+			}
+
+			if next < len(text) && text[next] == '(' {
+				output.WriteString("(" + identifier + " ")
+				calls = append(calls, true)
+				i = next + 1
+				continue
+			}
+
+			output.WriteString(identifier)
+			i = end
+		case c == '(':
+			output.WriteByte(c)
+			calls = append(calls, false)
+			i++
+		case c == ')':
+			if len(calls) == 0 {
+				return "", "", errors.New("unexpected parenthesis")
+			}
+
+			output.WriteByte(c)
+			calls = calls[:len(calls)-1]
+			i++
+		case c == ',' && len(calls) > 0 && calls[len(calls)-1]:
+			if i+1 < len(text) && text[i+1] != ' ' {
+				output.WriteByte(' ')
+			}
+
+			i++
+		default:
+			output.WriteByte(c)
+			i++
+		}
+	}
+
+	return "", "", errors.New("unclosed action")
+}
+
+// isOption returns true if the text after an identifier assigns its value with =
+func isOption(text string) bool {
+	text = strings.TrimLeft(text, " ")
+	return strings.HasPrefix(text, "=") && !strings.HasPrefix(text, "==")
+}
+
+// readOptionValue reads the value of an option up to the next comma or closing parenthesis,
+// quoted values may contain both
+func readOptionValue(text string, start int) (string, int, error) {
+	for start < len(text) && text[start] == ' ' {
+		start++
+	}
+
+	if start < len(text) && (text[start] == '\'' || text[start] == '"') {
+		quote := text[start]
+		end := start + 1
+
+		for end < len(text) && text[end] != quote {
+			if text[end] == '\\' {
+				end++
+			}
+
+			end++
+		}
+
+		if end >= len(text) {
+			return "", 0, errors.New("unterminated quoted string")
+		}
+
+		value := text[start+1 : end]
+
+		if quote == '"' {
+			unquoted, err := strconv.Unquote(text[start : end+1])
+
+			if err != nil {
+				return "", 0, err
+			}
+
+			value = unquoted
+		} else {
+			value = strings.Replace(value, `\'`, `'`, -1)
+		}
+
+		return value, end + 1, nil
+	}
+
+	end := start
+
+	for end < len(text) && text[end] != ',' && text[end] != ')' && !strings.HasPrefix(text[end:], "}}") {
+		end++
+	}
+
+	value := strings.TrimSpace(text[start:end])
+
+	if len(value) == 0 {
+		return "", 0, errors.New("option without value")
+	}
+
+	return value, end, nil
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierPart(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9')
+}